@@ -0,0 +1,87 @@
+package mbserver
+
+import "fmt"
+
+// addressRange is a range of addresses declared with MapAddresses.
+type addressRange struct {
+	start, end int
+}
+
+// contains returns true if every address of the range is declared in ranges.
+func contains(ranges []addressRange, address uint16, quantity int) bool {
+	pos, end := int(address), int(address)+quantity
+	for pos < end {
+		next := pos
+		for _, r := range ranges {
+			if r.start <= pos && pos < r.end && r.end > next {
+				next = r.end
+			}
+		}
+		if next == pos {
+			return false
+		}
+		pos = next
+	}
+	return true
+}
+
+// MapAddresses declares quantity addresses of table starting at address for device id.
+// Without declared addresses a device has the full address space 0-65535 in all four
+// tables. After the first call only the declared ranges exist, every request touching
+// an address outside of them is answered with IllegalDataAddress, like a real device.
+// For example:  s.MapAddresses(1, HoldingRegisterTable, 1000, 100)
+func (s *Server) MapAddresses(id byte, table Table, address uint16, quantity int) error {
+	return s.withDevice(id, func(device *Device) error {
+		if table > InputRegisterTable {
+			return fmt.Errorf("mbserver: invalid table %v", table)
+		}
+		if err := checkRange(address, quantity); err != nil {
+			return err
+		}
+		if device.addressMap == nil {
+			device.addressMap = map[Table][]addressRange{}
+		}
+		device.addressMap[table] = append(device.addressMap[table], addressRange{int(address), int(address) + quantity})
+		return nil
+	})
+}
+
+// Mapped returns true if all quantity addresses of table starting at address exist.
+func (d *Device) Mapped(table Table, address uint16, quantity int) bool {
+	if d.addressMap == nil {
+		return true
+	}
+	return contains(d.addressMap[table], address, quantity)
+}
+
+// ReadBits reads from the Store if the addresses are mapped.
+func (d *Device) ReadBits(table Table, address uint16, quantity int) ([]bool, Exception) {
+	if !d.Mapped(table, address, quantity) {
+		return nil, IllegalDataAddress
+	}
+	return d.Store.ReadBits(table, address, quantity)
+}
+
+// WriteBits writes to the Store if the addresses are mapped.
+func (d *Device) WriteBits(table Table, address uint16, values []bool) Exception {
+	if !d.Mapped(table, address, len(values)) {
+		return IllegalDataAddress
+	}
+	return d.Store.WriteBits(table, address, values)
+}
+
+// ReadRegisters reads from the Store if the addresses are mapped.
+func (d *Device) ReadRegisters(table Table, address uint16, quantity int) ([]uint16, Exception) {
+	if !d.Mapped(table, address, quantity) {
+		return nil, IllegalDataAddress
+	}
+	return d.Store.ReadRegisters(table, address, quantity)
+}
+
+// WriteRegisters writes to the Store if the addresses are mapped.
+func (d *Device) WriteRegisters(table Table, address uint16, values []uint16) Exception {
+	if !d.Mapped(table, address, len(values)) {
+		return IllegalDataAddress
+	}
+	return d.Store.WriteRegisters(table, address, values)
+}
@@ -0,0 +1,65 @@
+package mbserver
+
+import "testing"
+
+func TestMapAddresses(t *testing.T) {
+	s := NewServer()
+	s.MapAddresses(1, HoldingRegisterTable, 0, 100)
+	s.MapAddresses(1, HoldingRegisterTable, 1000, 100)
+	s.MapAddresses(1, HoldingRegisterTable, 1100, 10)
+	s.MapAddresses(1, CoilTable, 10, 8)
+
+	for _, test := range []struct {
+		function byte
+		address  uint16
+		number   uint16
+		expect   Exception
+	}{
+		{3, 0, 100, Success},
+		{3, 99, 2, IllegalDataAddress},
+		{3, 100, 1, IllegalDataAddress},
+		{3, 1050, 60, Success},
+		{3, 1105, 6, IllegalDataAddress},
+		{6, 1109, 1, Success},
+		{6, 1110, 1, IllegalDataAddress},
+		{16, 1098, 2, Success},
+		{1, 10, 8, Success},
+		{1, 9, 2, IllegalDataAddress},
+		{5, 17, 0xff00, Success},
+		{15, 17, 2, IllegalDataAddress},
+		{2, 0, 1, IllegalDataAddress},
+		{4, 0, 1, IllegalDataAddress},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function}
+		SetDataWithRegisterAndNumber(frame, test.address, test.number)
+		switch test.function {
+		case 15:
+			frame.Data = append(frame.Data, byte((test.number+7)/8))
+			frame.Data = append(frame.Data, make([]byte, (test.number+7)/8)...)
+		case 16:
+			frame.Data = append(frame.Data, byte(2*test.number))
+			frame.Data = append(frame.Data, make([]byte, 2*test.number)...)
+		}
+		response := s.handle(&Request{frame: frame})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("function %v address %v: expected %v, got %v", test.function, test.address, test.expect, got)
+		}
+	}
+
+	if err := s.SetHoldingRegisters(1, 100, []uint16{1}); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.MapAddresses(2, CoilTable, 0, 1); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.MapAddresses(1, CoilTable, 65535, 2); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
+
+func TestUnmappedDevice(t *testing.T) {
+	d := &Device{Store: NewMemoryStore()}
+	if !d.Mapped(InputRegisterTable, 0, 65536) {
+		t.Errorf("expected all addresses to be mapped")
+	}
+}
@@ -0,0 +1,67 @@
+package mbserver
+
+import "encoding/binary"
+
+// eventState returns the status word, the event counter, the bus message counter and a copy of the event log.
+func (d *serialDiagnostics) eventState() (status, eventCount, messageCount uint16, events []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The status word is 0xFFFF while a program command is processed, the server has none.
+	return 0, d.eventCount, d.counters[busMessageCount], append([]byte{}, d.events...)
+}
+
+// GetCommEventCounter function 11, returns the status word and the event counter of the serial line.
+// The event counter is incremented for each successfully processed request, except the
+// requests fetching the event counter, and is cleared by the Diagnostics sub-functions
+// Restart Communications Option and Clear Counters and Diagnostic Register.
+// The function is defined for serial lines only, requests received by TCP/IP are
+// answered with IllegalFunction.
+func GetCommEventCounter(s *Server, request *Request) ([]byte, Exception) {
+	frame := request.Frame()
+	device := frame.GetDevice()
+
+	if request.diag == nil {
+		infolog.Printf("GetCommEventCounter from Device %v by %v >> Exception: IllegalFunction\n", device, request.Transport)
+		return []byte{}, IllegalFunction
+	}
+	if len(frame.GetData()) != 0 {
+		infolog.Printf("GetCommEventCounter from Device %v >> Exception: IllegalDataValue, PDU length %v\n", device, len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
+
+	debuglog.Printf("GetCommEventCounter from Device %v\n", device)
+
+	status, eventCount, _, _ := request.diag.eventState()
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:2], status)
+	binary.BigEndian.PutUint16(data[2:4], eventCount)
+	return data, Success
+}
+
+// GetCommEventLog function 12, returns the status word, the event counter, the message
+// counter and the last 64 events of the serial line, the most recent event first.
+// The function is defined for serial lines only, requests received by TCP/IP are
+// answered with IllegalFunction.
+func GetCommEventLog(s *Server, request *Request) ([]byte, Exception) {
+	frame := request.Frame()
+	device := frame.GetDevice()
+
+	if request.diag == nil {
+		infolog.Printf("GetCommEventLog from Device %v by %v >> Exception: IllegalFunction\n", device, request.Transport)
+		return []byte{}, IllegalFunction
+	}
+	if len(frame.GetData()) != 0 {
+		infolog.Printf("GetCommEventLog from Device %v >> Exception: IllegalDataValue, PDU length %v\n", device, len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
+
+	debuglog.Printf("GetCommEventLog from Device %v\n", device)
+
+	status, eventCount, messageCount, events := request.diag.eventState()
+	data := make([]byte, 7, 7+len(events))
+	data[0] = byte(6 + len(events))
+	binary.BigEndian.PutUint16(data[1:3], status)
+	binary.BigEndian.PutUint16(data[3:5], eventCount)
+	binary.BigEndian.PutUint16(data[5:7], messageCount)
+	return append(data, events...), Success
+}
@@ -0,0 +1,62 @@
+package mbserver
+
+// lookup returns the handler of a function code for a device. deviceMu must be held.
+func (s *Server) lookup(id byte, funcCode uint8) RequestHandler {
+	if device, ok := s.Devices[id]; ok {
+		if device.disabled[funcCode] {
+			return illegalFunction
+		}
+		if function, ok := device.function[funcCode]; ok {
+			return function
+		}
+	}
+	if s.function[funcCode] == nil {
+		return illegalFunction
+	}
+	return s.function[funcCode]
+}
+
+// RegisterDeviceHandler overrides the handler of a Modbus function for device id only.
+// The other devices keep using the handlers of the server.
+func (s *Server) RegisterDeviceHandler(id byte, funcCode uint8, handler RequestHandler) error {
+	return s.withDevice(id, func(device *Device) error {
+		if device.function == nil {
+			device.function = map[uint8]RequestHandler{}
+		}
+		device.function[funcCode] = handler
+		return nil
+	})
+}
+
+// DisableFunctions answers the requests of the function codes to device id with IllegalFunction.
+func (s *Server) DisableFunctions(id byte, funcCodes ...uint8) error {
+	return s.setFunctions(id, funcCodes, true)
+}
+
+// EnableFunctions enables function codes of device id disabled by DisableFunctions or LimitFunctions.
+func (s *Server) EnableFunctions(id byte, funcCodes ...uint8) error {
+	return s.setFunctions(id, funcCodes, false)
+}
+
+// LimitFunctions disables all function codes of device id except funcCodes,
+// e.g. s.LimitFunctions(2, 3, 4) for a meter that only supports reading registers.
+func (s *Server) LimitFunctions(id byte, funcCodes ...uint8) error {
+	return s.withDevice(id, func(device *Device) error {
+		for funcCode := range device.disabled {
+			device.disabled[funcCode] = true
+		}
+		for _, funcCode := range funcCodes {
+			device.disabled[funcCode] = false
+		}
+		return nil
+	})
+}
+
+func (s *Server) setFunctions(id byte, funcCodes []uint8, disabled bool) error {
+	return s.withDevice(id, func(device *Device) error {
+		for _, funcCode := range funcCodes {
+			device.disabled[funcCode] = disabled
+		}
+		return nil
+	})
+}
@@ -0,0 +1,54 @@
+package mbserver
+
+import "testing"
+
+func TestDeviceFunctions(t *testing.T) {
+	s := NewServer()
+	s.NewDevice(2)
+	s.NewDevice(3)
+	// Device 2 is a meter only reading registers.
+	s.LimitFunctions(2, 3, 4)
+	// Device 3 has its own ReadHoldingRegisters and doesn't support WriteSingleCoil.
+	s.RegisterDeviceHandler(3, 3, func(s *Server, request *Request) ([]byte, Exception) {
+		return []byte{2, 0xab, 0xcd}, Success
+	})
+	s.DisableFunctions(3, 5, 6)
+	s.EnableFunctions(3, 6)
+
+	for _, test := range []struct {
+		device   byte
+		function byte
+		expect   Exception
+		data     []byte
+	}{
+		{1, 3, Success, []byte{2, 0, 0}},
+		{1, 6, Success, []byte{0, 1, 0, 1}},
+		{2, 3, Success, []byte{2, 0, 0}},
+		{2, 4, Success, []byte{2, 0, 0}},
+		{2, 1, IllegalFunction, nil},
+		{2, 6, IllegalFunction, nil},
+		{3, 3, Success, []byte{2, 0xab, 0xcd}},
+		{3, 5, IllegalFunction, nil},
+		{3, 6, Success, []byte{0, 1, 0, 1}},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: test.device, Function: test.function}
+		SetDataWithRegisterAndNumber(frame, 1, 1)
+		response := s.handle(&Request{frame: frame})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("device %v function %v: expected %v, got %v", test.device, test.function, test.expect, got)
+		}
+		if got := response.GetData(); test.expect == Success && !isEqual(test.data, got) {
+			t.Errorf("device %v function %v: expected %v, got %v", test.device, test.function, test.data, got)
+		}
+	}
+
+	if err := s.RegisterDeviceHandler(4, 3, nil); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.LimitFunctions(4, 3); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.DisableFunctions(4, 3); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
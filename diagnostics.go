@@ -0,0 +1,329 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Counters of a serial line returned by the Diagnostics sub-functions 0x0B to 0x12.
+const (
+	busMessageCount = iota
+	busCommunicationErrorCount
+	busExceptionErrorCount
+	serverMessageCount
+	serverNoResponseCount
+	serverNAKCount
+	serverBusyCount
+	busCharacterOverrunCount
+	counterCount
+)
+
+// eventLogSize is the number of events of the communication event log.
+const eventLogSize = 64
+
+// Events of the communication event log.
+const (
+	// receiveEvent is stored when a request is received.
+	receiveEvent              = 0x80
+	receiveCommunicationError = 0x02
+	receiveCharacterOverrun   = 0x10
+	receiveListenOnly         = 0x20
+	receiveBroadcast          = 0x40
+	// sendEvent is stored when a request was processed.
+	sendEvent                 = 0x40
+	sendReadException         = 0x01
+	sendAbortException        = 0x02
+	sendBusyException         = 0x04
+	sendNAKException          = 0x08
+	sendListenOnly            = 0x20
+	listenOnlyEvent           = 0x04
+	communicationRestartEvent = 0x00
+)
+
+// serialDiagnostics is the diagnostic state of a serial line, maintained by the
+// request path and returned by Diagnostics, GetCommEventCounter and GetCommEventLog.
+// The methods may be called on nil, for requests not received from a serial line.
+type serialDiagnostics struct {
+	mu         sync.Mutex
+	counters   [counterCount]uint16
+	listenOnly bool
+	// delimiter is the end delimiter of ASCII frames.
+	delimiter byte
+	// eventCount is the number of successfully processed requests.
+	eventCount uint16
+	// events is the communication event log, the most recent event first.
+	events []byte
+}
+
+func newSerialDiagnostics() *serialDiagnostics {
+	return &serialDiagnostics{delimiter: '\n'}
+}
+
+func (d *serialDiagnostics) count(counter int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters[counter]++
+}
+
+// addEvent stores an event in the event log. d.mu must be held.
+func (d *serialDiagnostics) addEvent(event byte) {
+	d.events = append([]byte{event}, d.events...)
+	if len(d.events) > eventLogSize {
+		d.events = d.events[:eventLogSize]
+	}
+}
+
+// received counts a request received for this server and stores the receive event.
+func (d *serialDiagnostics) received(broadcast bool) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.counters[serverMessageCount]++
+	event := byte(receiveEvent)
+	if d.listenOnly {
+		event |= receiveListenOnly
+	}
+	if broadcast {
+		event |= receiveBroadcast
+	}
+	d.addEvent(event)
+}
+
+// lineError counts a frame with a communication error or a character overrun
+// and stores the receive event.
+func (d *serialDiagnostics) lineError(counter int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.counters[counter]++
+	event := byte(receiveEvent)
+	if counter == busCharacterOverrunCount {
+		event |= receiveCharacterOverrun
+	} else {
+		event |= receiveCommunicationError
+	}
+	if d.listenOnly {
+		event |= receiveListenOnly
+	}
+	d.addEvent(event)
+}
+
+// completed counts the exception of a processed request and stores the send event.
+// The requests of the functions fetching the event counter don't increment it.
+func (d *serialDiagnostics) completed(function uint8, exception Exception) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	event := byte(sendEvent)
+	switch exception {
+	case Success:
+		if function != 11 && function != 12 {
+			d.eventCount++
+		}
+	case IllegalFunction, IllegalDataAddress, IllegalDataValue:
+		event |= sendReadException
+	case SlaveDeviceFailure:
+		event |= sendAbortException
+	case AcknowledgeSlave, SlaveDeviceBusy:
+		event |= sendBusyException
+	case NegativeAcknowledge:
+		event |= sendNAKException
+	}
+	if d.listenOnly {
+		event |= sendListenOnly
+	}
+	d.addEvent(event)
+}
+
+func (d *serialDiagnostics) counter(counter int) uint16 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counters[counter]
+}
+
+// countResponse counts the exception responses.
+func (d *serialDiagnostics) countResponse(exception Exception) {
+	switch exception {
+	case Success:
+	case NegativeAcknowledge:
+		d.count(busExceptionErrorCount)
+		d.count(serverNAKCount)
+	case SlaveDeviceBusy:
+		d.count(busExceptionErrorCount)
+		d.count(serverBusyCount)
+	default:
+		d.count(busExceptionErrorCount)
+	}
+}
+
+// clearCounters clears all counters and the event counter.
+func (d *serialDiagnostics) clearCounters() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters = [counterCount]uint16{}
+	d.eventCount = 0
+}
+
+func (d *serialDiagnostics) clearOverrun() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters[busCharacterOverrunCount] = 0
+}
+
+// restart clears the counters, leaves the listen only mode and clears the event log if clearLog is true.
+func (d *serialDiagnostics) restart(clearLog bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters = [counterCount]uint16{}
+	d.eventCount = 0
+	d.listenOnly = false
+	if clearLog {
+		d.events = nil
+	}
+	d.addEvent(communicationRestartEvent)
+}
+
+func (d *serialDiagnostics) setListenOnly() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.listenOnly = true
+	d.addEvent(listenOnlyEvent)
+}
+
+// isListenOnly returns true if the line must not send responses.
+func (d *serialDiagnostics) isListenOnly() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.listenOnly
+}
+
+func (d *serialDiagnostics) setDelimiter(delimiter byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.delimiter = delimiter
+}
+
+// asciiDelimiter returns the end delimiter of ASCII frames, LF by default.
+func (d *serialDiagnostics) asciiDelimiter() byte {
+	if d == nil {
+		return '\n'
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.delimiter
+}
+
+// isRestart returns true if the frame is a Restart Communications Option request,
+// the only request handled in listen only mode.
+func isRestart(frame Framer) bool {
+	data := frame.GetData()
+	return frame.GetFunction() == 8 && len(data) >= 2 && binary.BigEndian.Uint16(data[0:2]) == 0x0001
+}
+
+// SetDiagnosticRegister sets the diagnostic register of device id returned by the
+// Diagnostics sub-function Return Diagnostic Register.
+func (s *Server) SetDiagnosticRegister(id byte, value uint16) error {
+	return s.withDevice(id, func(device *Device) error {
+		device.diagnosticRegister = value
+		return nil
+	})
+}
+
+// Diagnostics function 8, tests the serial line and returns its counters.
+// The function is defined for serial lines only, requests received by TCP/IP are
+// answered with IllegalFunction, including RTU over TCP. The counters are kept per serial line.
+func Diagnostics(s *Server, request *Request) ([]byte, Exception) {
+	frame := request.Frame()
+	device := frame.GetDevice()
+	data := frame.GetData()
+	diag := request.diag
+
+	if diag == nil {
+		infolog.Printf("Diagnostics from Device %v by %v >> Exception: IllegalFunction\n", device, request.Transport)
+		return []byte{}, IllegalFunction
+	}
+	if len(data) < 4 || len(data)%2 != 0 {
+		infolog.Printf("Diagnostics from Device %v >> Exception: IllegalDataValue, PDU length %v\n", device, len(data))
+		return []byte{}, IllegalDataValue
+	}
+
+	subFunction := binary.BigEndian.Uint16(data[0:2])
+	value := binary.BigEndian.Uint16(data[2:4])
+	debuglog.Printf("Diagnostics from Device %v, sub-function %v, data %v\n", device, subFunction, data[2:])
+
+	// All sub-functions except Return Query Data have a data field of 2 bytes.
+	if subFunction != 0x00 && len(data) != 4 {
+		infolog.Printf("Diagnostics from Device %v, sub-function %v >> Exception: IllegalDataValue, PDU length %v\n", device, subFunction, len(data))
+		return []byte{}, IllegalDataValue
+	}
+
+	// The data field is 0x0000 except for the sub-functions Return Query Data,
+	// Restart Communications Option and Change ASCII Input Delimiter.
+	switch {
+	case subFunction == 0x00:
+	case subFunction == 0x01 && value == 0xff00:
+	case subFunction == 0x03 && data[3] == 0:
+	case value != 0:
+		infolog.Printf("Diagnostics from Device %v, sub-function %v >> Exception: IllegalDataValue, data %v\n", device, subFunction, value)
+		return []byte{}, IllegalDataValue
+	}
+
+	switch subFunction {
+	case 0x00:
+		// Return Query Data
+		return data, Success
+	case 0x01:
+		// Restart Communications Option
+		diag.restart(value == 0xff00)
+		return data, Success
+	case 0x02:
+		// Return Diagnostic Register
+		return subFunctionData(subFunction, s.Devices[device].diagnosticRegister), Success
+	case 0x03:
+		// Change ASCII Input Delimiter
+		diag.setDelimiter(data[2])
+		return data, Success
+	case 0x04:
+		// Force Listen Only Mode, no response is returned.
+		diag.setListenOnly()
+		return data, Success
+	case 0x0a:
+		// Clear Counters and Diagnostic Register
+		diag.clearCounters()
+		s.Devices[device].diagnosticRegister = 0
+		return data, Success
+	case 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12:
+		// Return Bus Message Count ... Return Bus Character Overrun Count
+		return subFunctionData(subFunction, diag.counter(int(subFunction-0x0b))), Success
+	case 0x14:
+		// Clear Overrun Counter and Flag
+		diag.clearOverrun()
+		return data, Success
+	}
+
+	infolog.Printf("Diagnostics from Device %v, sub-function %v >> Exception: IllegalFunction\n", device, subFunction)
+	return []byte{}, IllegalFunction
+}
+
+// subFunctionData returns the sub-function followed by the value.
+func subFunctionData(subFunction, value uint16) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:2], subFunction)
+	binary.BigEndian.PutUint16(data[2:4], value)
+	return data
+}
@@ -0,0 +1,162 @@
+package mbserver
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDiagnostics(t *testing.T) {
+	pr, pw := io.Pipe()
+	port := &asciiPort{PipeReader: pr, responses: make(chan []byte, 4)}
+
+	s := NewServer()
+	s.SetDiagnosticRegister(1, 0x1234)
+	if err := s.ListenASCII(port, 0); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	diagnostics := func(subFunction, value uint16) *ASCIIFrame {
+		return &ASCIIFrame{Address: 1, Function: 8, Data: subFunctionData(subFunction, value)}
+	}
+	readRegister := &ASCIIFrame{Address: 1, Function: 3, Data: []byte{0, 0, 0, 1}}
+
+	for i, test := range []struct {
+		request []byte
+		// response is the data of the response, nil if no response is expected.
+		response  []byte
+		exception Exception
+	}{
+		{diagnostics(0x00, 0xa537).Bytes(), subFunctionData(0x00, 0xa537), Success},
+		{readRegister.Bytes(), []byte{2, 0, 0}, Success},
+		// Bad LRC.
+		{[]byte(":010300000001FA\r\n"), nil, Success},
+		// Unknown device.
+		{(&ASCIIFrame{Address: 5, Function: 3, Data: []byte{0, 0, 0, 1}}).Bytes(), nil, Success},
+		{(&ASCIIFrame{Address: 1, Function: 3, Data: []byte{0, 0, 0, 0}}).Bytes(), []byte{}, IllegalDataValue},
+		{diagnostics(0x02, 0).Bytes(), subFunctionData(0x02, 0x1234), Success},
+		{diagnostics(0x0b, 0).Bytes(), subFunctionData(0x0b, 7), Success},
+		{diagnostics(0x0c, 0).Bytes(), subFunctionData(0x0c, 1), Success},
+		{diagnostics(0x0d, 0).Bytes(), subFunctionData(0x0d, 1), Success},
+		{diagnostics(0x0e, 0).Bytes(), subFunctionData(0x0e, 8), Success},
+		{diagnostics(0x0e, 1).Bytes(), []byte{}, IllegalDataValue},
+		{diagnostics(0x13, 0).Bytes(), []byte{}, IllegalFunction},
+		// Listen only mode, requests are not answered until the restart.
+		{diagnostics(0x04, 0).Bytes(), nil, Success},
+		{readRegister.Bytes(), nil, Success},
+		{diagnostics(0x01, 0).Bytes(), nil, Success},
+		{diagnostics(0x0f, 0).Bytes(), subFunctionData(0x0f, 1), Success},
+		{diagnostics(0x0a, 0).Bytes(), subFunctionData(0x0a, 0), Success},
+		{diagnostics(0x02, 0).Bytes(), subFunctionData(0x02, 0), Success},
+		{diagnostics(0x0e, 0).Bytes(), subFunctionData(0x0e, 2), Success},
+		{diagnostics(0x01, 0x1234).Bytes(), []byte{}, IllegalDataValue},
+		// Change the ASCII input delimiter to '!'.
+		{diagnostics(0x03, 0x2100).Bytes(), subFunctionData(0x03, 0x2100), Success},
+		{append(readRegister.Bytes()[:16], '!'), []byte{2, 0, 0}, Success},
+	} {
+		if _, err := pw.Write(test.request); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if test.response == nil {
+			continue
+		}
+
+		select {
+		case got := <-port.responses:
+			response, err := NewASCIIFrame(got)
+			if err != nil {
+				t.Fatalf("%v: expected nil, got %v\n", i, err)
+			}
+			if exception := GetException(response); exception != test.exception {
+				t.Errorf("%v: expected %v, got %v", i, test.exception, exception)
+			}
+			if test.exception == Success && !isEqual(test.response, response.GetData()) {
+				t.Errorf("%v: expected %v, got %v", i, test.response, response.GetData())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected %v, got timeout", i, test.response)
+		}
+	}
+}
+
+func TestDiagnosticsTCP(t *testing.T) {
+	s := NewServer()
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	response := exchangeTCP(t, addr, &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 8, Data: subFunctionData(0, 0)})
+	if got := GetException(response); got != IllegalFunction {
+		t.Errorf("expected %v, got %v", IllegalFunction, got)
+	}
+}
+
+func TestCommEvents(t *testing.T) {
+	pr, pw := io.Pipe()
+	port := &asciiPort{PipeReader: pr, responses: make(chan []byte, 4)}
+
+	s := NewServer()
+	if err := s.ListenASCII(port, 0); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	for i, test := range []struct {
+		request   *ASCIIFrame
+		response  []byte
+		exception Exception
+	}{
+		{&ASCIIFrame{Address: 1, Function: 3, Data: []byte{0, 0, 0, 1}}, []byte{2, 0, 0}, Success},
+		{&ASCIIFrame{Address: 1, Function: 3, Data: []byte{0, 0, 0, 0}}, []byte{}, IllegalDataValue},
+		{&ASCIIFrame{Address: 1, Function: 11}, []byte{0, 0, 0, 1}, Success},
+		{&ASCIIFrame{Address: 1, Function: 11, Data: []byte{0}}, []byte{}, IllegalDataValue},
+		// The receive event of the request itself is the first event.
+		{&ASCIIFrame{Address: 1, Function: 12}, []byte{15, 0, 0, 0, 1, 0, 5, 0x80, 0x41, 0x80, 0x40, 0x80, 0x41, 0x80, 0x40, 0x80}, Success},
+		// Restart Communications Option clearing the log.
+		{&ASCIIFrame{Address: 1, Function: 8, Data: subFunctionData(0x01, 0xff00)}, subFunctionData(0x01, 0xff00), Success},
+		{&ASCIIFrame{Address: 1, Function: 12}, []byte{9, 0, 0, 0, 1, 0, 1, 0x80, 0x40, 0x00}, Success},
+	} {
+		if _, err := pw.Write(test.request.Bytes()); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+
+		select {
+		case got := <-port.responses:
+			response, err := NewASCIIFrame(got)
+			if err != nil {
+				t.Fatalf("%v: expected nil, got %v\n", i, err)
+			}
+			if exception := GetException(response); exception != test.exception {
+				t.Errorf("%v: expected %v, got %v", i, test.exception, exception)
+			}
+			if test.exception == Success && !isEqual(test.response, response.GetData()) {
+				t.Errorf("%v: expected %v, got %v", i, test.response, response.GetData())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected %v, got timeout", i, test.response)
+		}
+	}
+}
+
+func TestCommEventsTCP(t *testing.T) {
+	s := NewServer()
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	for _, function := range []uint8{11, 12} {
+		response := exchangeTCP(t, addr, &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: function})
+		if got := GetException(response); got != IllegalFunction {
+			t.Errorf("function %v: expected %v, got %v", function, IllegalFunction, got)
+		}
+	}
+}
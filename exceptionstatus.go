@@ -0,0 +1,67 @@
+package mbserver
+
+// exceptionStatus contains the eight exception status outputs of a device.
+type exceptionStatus struct {
+	value byte
+	// bound is true if the outputs are the coils starting at address.
+	bound   bool
+	address uint16
+}
+
+// SetExceptionStatus sets the eight exception status outputs of device id returned by
+// Read Exception Status (function 7), output 0 is the least significant bit.
+// It removes the binding of BindExceptionStatus.
+func (s *Server) SetExceptionStatus(id byte, status byte) error {
+	return s.withDevice(id, func(device *Device) error {
+		device.exceptionStatus = exceptionStatus{value: status}
+		return nil
+	})
+}
+
+// BindExceptionStatus binds the eight exception status outputs of device id to the
+// coils starting at address, so Read Exception Status returns the current coil values.
+func (s *Server) BindExceptionStatus(id byte, address uint16) error {
+	return s.withDevice(id, func(device *Device) error {
+		if err := checkRange(address, 8); err != nil {
+			return err
+		}
+		device.exceptionStatus = exceptionStatus{bound: true, address: address}
+		return nil
+	})
+}
+
+// ReadExceptionStatus function 7, reads the eight exception status outputs of a device.
+// The function is defined for serial lines only, requests received by TCP/IP are
+// answered with IllegalFunction, except RTU over TCP tunneling a serial line.
+func ReadExceptionStatus(s *Server, request *Request) ([]byte, Exception) {
+	frame := request.Frame()
+	device := frame.GetDevice()
+
+	if !request.Transport.Serial() && request.Transport != TransportRTUOverTCP {
+		infolog.Printf("ReadExceptionStatus from Device %v by %v >> Exception: IllegalFunction\n", device, request.Transport)
+		return []byte{}, IllegalFunction
+	}
+	if len(frame.GetData()) != 0 {
+		infolog.Printf("ReadExceptionStatus from Device %v >> Exception: IllegalDataValue, PDU length %v\n", device, len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
+
+	debuglog.Printf("ReadExceptionStatus from Device %v\n", device)
+
+	status := s.Devices[device].exceptionStatus
+	if !status.bound {
+		return []byte{status.value}, Success
+	}
+
+	// The read hooks of the coils are called like for ReadCoils.
+	values, exception := s.readBits(device, CoilTable, status.address, 8)
+	if exception != Success {
+		infolog.Printf("ReadExceptionStatus from Device %v, Address %v >> Exception: %v\n", device, status.address, exception)
+		// The master didn't request the address, an unmapped coil is a failure of the device.
+		if exception == IllegalDataAddress {
+			exception = SlaveDeviceFailure
+		}
+		return []byte{}, exception
+	}
+	return packBits(values)[1:], Success
+}
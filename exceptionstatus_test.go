@@ -0,0 +1,62 @@
+package mbserver
+
+import "testing"
+
+func TestReadExceptionStatus(t *testing.T) {
+	s := NewServer()
+	s.NewDevice(2)
+	s.SetExceptionStatus(1, 0x6d)
+	s.SetCoils(2, 100, []bool{true, false, true})
+	s.BindExceptionStatus(2, 100)
+
+	// Read Exception Status of device 1 by RTU: 01 07 41 E2
+	packet := []byte{0x01, 0x07, 0x41, 0xe2}
+	frame, err := NewRTUFrame(packet)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	response := s.handle(&Request{frame: frame, Transport: TransportRTU})
+	expect := []byte{0x01, 0x07, 0x6d, 0xe3, 0xdd}
+	if got := response.Bytes(); !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	for _, test := range []struct {
+		device    byte
+		transport Transport
+		data      []byte
+		expect    Exception
+		status    []byte
+	}{
+		{2, TransportASCII, nil, Success, []byte{0x05}},
+		{1, TransportTCP, nil, IllegalFunction, nil},
+		{1, TransportRTUOverTCP, nil, Success, []byte{0x6d}},
+		{1, TransportRTU, []byte{0}, IllegalDataValue, nil},
+	} {
+		frame := &RTUFrame{Address: test.device, Function: 7, Data: test.data}
+		response := s.handle(&Request{frame: frame, Transport: test.transport})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("device %v %v: expected %v, got %v", test.device, test.transport, test.expect, got)
+		}
+		if got := response.GetData(); test.expect == Success && !isEqual(test.status, got) {
+			t.Errorf("device %v %v: expected %v, got %v", test.device, test.transport, test.status, got)
+		}
+	}
+
+	// The read hooks of the bound coils are called.
+	s.OnRead(2, CoilTable, 107, 1, func(m Memory, id byte, table Table, address uint16, quantity int) Exception {
+		m.SetCoils(id, address, []bool{true})
+		return Success
+	})
+	response = s.handle(&Request{frame: &RTUFrame{Address: 2, Function: 7}, Transport: TransportRTU})
+	if got := response.GetData(); !isEqual([]byte{0x85}, got) {
+		t.Errorf("expected %v, got %v", []byte{0x85}, got)
+	}
+
+	if err := s.BindExceptionStatus(2, 65530); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.SetExceptionStatus(3, 0); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// asciiMaxSize is the maximum size of a Modbus ASCII frame in characters.
+const asciiMaxSize = 513
+
+// ASCIIFrame is the Modbus ASCII frame.
+type ASCIIFrame struct {
+	Address  uint8
+	Function uint8
+	Data     []byte
+	LRC      uint8
+}
+
+// NewASCIIFrame converts a packet to a Modbus ASCII frame.
+// The packet starts with a colon, contains the hex encoded address, function,
+// data and LRC and ends with CR LF.
+func NewASCIIFrame(packet []byte) (*ASCIIFrame, error) {
+	// Check the that the packet length.
+	if len(packet) < 9 {
+		return nil, fmt.Errorf("ASCII Frame error: packet less than 9 characters: %q", packet)
+	}
+	pLen := len(packet)
+	if packet[0] != ':' || packet[pLen-2] != '\r' || packet[pLen-1] != '\n' {
+		return nil, fmt.Errorf("ASCII Frame error: missing start or end of frame: %q", packet)
+	}
+
+	bytes := make([]byte, hex.DecodedLen(pLen-3))
+	if _, err := hex.Decode(bytes, packet[1:pLen-2]); err != nil {
+		return nil, fmt.Errorf("ASCII Frame error: %v", err)
+	}
+
+	// Check the LRC.
+	bLen := len(bytes)
+	lrcExpect := bytes[bLen-1]
+	lrcCalc := lrcModbus(bytes[0 : bLen-1])
+	if lrcCalc != lrcExpect {
+		return nil, fmt.Errorf("ASCII Frame error: LRC (expected 0x%x, got 0x%x)", lrcExpect, lrcCalc)
+	}
+
+	frame := &ASCIIFrame{
+		Address:  bytes[0],
+		Function: bytes[1],
+		Data:     bytes[2 : bLen-1],
+		LRC:      lrcCalc,
+	}
+
+	return frame, nil
+}
+
+// lrcModbus calculates the longitudinal redundancy check: the two's complement
+// of the sum of all bytes.
+func lrcModbus(data []byte) uint8 {
+	var sum uint8
+	for _, v := range data {
+		sum += v
+	}
+	return -sum
+}
+
+// Copy the ASCIIFrame.
+func (frame *ASCIIFrame) Copy() Framer {
+	copy := *frame
+	return &copy
+}
+
+// Bytes returns the Modbus character stream based on the ASCIIFrame fields
+func (frame *ASCIIFrame) Bytes() []byte {
+	bytes := make([]byte, 2)
+
+	bytes[0] = frame.Address
+	bytes[1] = frame.Function
+	bytes = append(bytes, frame.Data...)
+
+	// Add the LRC.
+	bytes = append(bytes, lrcModbus(bytes))
+
+	return []byte(":" + strings.ToUpper(hex.EncodeToString(bytes)) + "\r\n")
+}
+
+// GetDevice returns the Modbus DeviceId.
+func (frame *ASCIIFrame) GetDevice() uint8 {
+	return frame.Address
+}
+
+// SetDevice set the ASCIIFrame Modbus DeviceId.
+func (frame *ASCIIFrame) SetDevice(id uint8) {
+	frame.Address = id
+}
+
+// GetFunction returns the Modbus function code.
+func (frame *ASCIIFrame) GetFunction() uint8 {
+	return frame.Function
+}
+
+// GetData returns the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) GetData() []byte {
+	return frame.Data
+}
+
+// SetData sets the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) SetData(data []byte) {
+	frame.Data = data
+}
+
+// SetException sets the Modbus exception code in the frame.
+func (frame *ASCIIFrame) SetException(exception Exception) {
+	frame.Function = frame.Function | 0x80
+	frame.Data = []byte{byte(exception)}
+}
+
+func (frame *ASCIIFrame) GetFrameParts() (register uint16, numRegs int, device uint8, exception Exception, err error) {
+	data := frame.GetData()
+	start := int(binary.BigEndian.Uint16(data[0:2]))
+	numRegs = int(binary.BigEndian.Uint16(data[2:4]))
+	device = frame.Address
+
+	if end := start + numRegs; end > 65536 {
+		err = fmt.Errorf("mbmaster: illegal data address %v\n", end)
+		exception = IllegalDataAddress
+		return
+	}
+
+	if device < idmin || device > idmax {
+		err = fmt.Errorf("mbmaster: invalid modbus id %v\n", device)
+		exception = SlaveDeviceFailure
+		return
+	}
+
+	register = uint16(start)
+	exception = Success
+	return
+}
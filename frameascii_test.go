@@ -0,0 +1,69 @@
+package mbserver
+
+import "testing"
+
+func TestNewASCIIFrame(t *testing.T) {
+	// Read holding registers, device 17, address 0x006B, quantity 3.
+	frame, err := NewASCIIFrame([]byte(":1103006B00037E\r\n"))
+	if !isEqual(nil, err) {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+
+	got := frame.Address
+	expect := 0x11
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	got = frame.Function
+	expect = 3
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	if expectData := []byte{0x00, 0x6b, 0x00, 0x03}; !isEqual(expectData, frame.Data) {
+		t.Errorf("expected %v, got %v", expectData, frame.Data)
+	}
+}
+
+func TestNewASCIIFrameShortPacket(t *testing.T) {
+	_, err := NewASCIIFrame([]byte(":0107\r\n"))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestNewASCIIFrameBadLRC(t *testing.T) {
+	// Bad LRC: 0x7F (should be 0x7E)
+	_, err := NewASCIIFrame([]byte(":1103006B00037F\r\n"))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestNewASCIIFrameBadFraming(t *testing.T) {
+	for _, packet := range []string{
+		"1103006B00037E\r\n:",
+		":1103006B00037E\n\r",
+		":1103006B0X037E\r\n",
+		":1103006B00037\r\n",
+	} {
+		if _, err := NewASCIIFrame([]byte(packet)); err == nil {
+			t.Errorf("%q: expected error not nil, got %v", packet, err)
+		}
+	}
+}
+
+func TestASCIIFrameBytes(t *testing.T) {
+	frame := &ASCIIFrame{
+		Address:  uint8(0x11),
+		Function: uint8(3),
+		Data:     []byte{0x00, 0x6b, 0x00, 0x03},
+	}
+
+	got := string(frame.Bytes())
+	expect := ":1103006B00037E\r\n"
+	if got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
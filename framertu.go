@@ -2,7 +2,9 @@ package mbserver
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"io"
 )
 
 // RTUFrame is the Modbus RTU frame.
@@ -15,9 +17,9 @@ type RTUFrame struct {
 
 // NewRTUFrame converts a packet to a Modbus RTU frame.
 func NewRTUFrame(packet []byte) (*RTUFrame, error) {
-	// Check the that the packet length.
-	if len(packet) < 5 {
-		return nil, fmt.Errorf("RTU Frame error: packet less than 5 bytes: %v", packet)
+	// Check the that the packet length, requests like Read Exception Status have no data.
+	if len(packet) < 4 {
+		return nil, fmt.Errorf("RTU Frame error: packet less than 4 bytes: %v", packet)
 	}
 
 	// Check the CRC.
@@ -118,3 +120,177 @@ func (frame *RTUFrame) GetFrameParts() (register uint16, numRegs int, device uin
 	exception = Success
 	return
 }
+
+// rtuMaxSize is the maximum size of a Modbus RTU frame.
+const rtuMaxSize = 256
+
+// rtuQueryData is returned by rtuRequestLength for the Diagnostics sub-function
+// Return Query Data. Its data has no byte count, so the length is found by the CRC.
+const rtuQueryData = -2
+
+// rtuRequestLength predicts the length of the RTU request frame at the start of
+// data from its function code. It returns 0 if more data is required, -1 if
+// data can't be the start of a request frame and rtuQueryData for a Return Query Data request.
+func rtuRequestLength(data []byte) int {
+	if len(data) > 0 && data[0] > idmax {
+		return -1
+	}
+	if len(data) < 2 {
+		return 0
+	}
+
+	// byteCount returns the length of a frame with a byte count field at position pos.
+	byteCount := func(pos, fixed int) int {
+		if len(data) <= pos {
+			return 0
+		}
+		return fixed + int(data[pos])
+	}
+
+	var length int
+	switch data[1] {
+	case 1, 2, 3, 4, 5, 6:
+		length = 8
+	case 8:
+		if len(data) < 4 {
+			return 0
+		}
+		if binary.BigEndian.Uint16(data[2:4]) == 0x0000 {
+			return rtuQueryData
+		}
+		length = 8
+	case 7, 11, 12, 17:
+		length = 4
+	case 15, 16:
+		length = byteCount(6, 9)
+	case 20, 21:
+		length = byteCount(2, 5)
+	case 22:
+		length = 10
+	case 23:
+		length = byteCount(10, 13)
+	case 24:
+		length = 6
+	case 43:
+		length = 7
+	default:
+		return -1
+	}
+
+	if length > rtuMaxSize {
+		return -1
+	}
+	return length
+}
+
+// scanQueryData returns the Return Query Data request at the start of data, the
+// shortest frame of even length with a matching CRC. If there is none, more is
+// true if the request may be completed by more data.
+func scanQueryData(data []byte) (frame []byte, more bool) {
+	for length := 8; length <= len(data) && length <= rtuMaxSize; length += 2 {
+		if crcModbus(data[:length-2]) == binary.LittleEndian.Uint16(data[length-2:length]) {
+			return data[:length], false
+		}
+	}
+	return nil, len(data) < rtuMaxSize
+}
+
+// ScanRTUFrames is a split function for a bufio.Scanner that cuts a serial byte
+// stream into Modbus RTU request frames. The length of a frame is predicted from
+// its function code, so several frames received in one read are separated
+// without relying on silent intervals. The length of a Return Query Data request
+// (Diagnostics sub-function 0) is found by its CRC. After line noise the stream is
+// resynchronised by sliding byte by byte until a frame with a matching CRC is found.
+// Requests with a function code without a known length can't be delimited and are
+// skipped, this includes function codes added by RegisterFunctionHandler or RegisterHandler.
+func ScanRTUFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for start := 0; start < len(data); start++ {
+		length := rtuRequestLength(data[start:])
+		if length == rtuQueryData {
+			frame, more := scanQueryData(data[start:])
+			if frame != nil {
+				return start + len(frame), frame, nil
+			}
+			if more && !atEOF {
+				// Wait for the rest of the frame.
+				return start, nil, nil
+			}
+			continue
+		}
+		if length < 0 {
+			continue
+		}
+		if length == 0 || start+length > len(data) {
+			if atEOF {
+				continue
+			}
+			// Wait for the rest of the frame.
+			return start, nil, nil
+		}
+
+		frame := data[start : start+length]
+		if crcModbus(frame[:length-2]) == binary.LittleEndian.Uint16(frame[length-2:]) {
+			return start + length, frame, nil
+		}
+	}
+	return len(data), nil, nil
+}
+
+// rtuScanner delimits RTU frames by their predicted length.
+type rtuScanner struct {
+	chunks <-chan serialChunk
+	buffer []byte
+	// skipping is true while data is skipped until the next frame.
+	skipping bool
+	// diag counts the skipped data, it may be nil.
+	diag *serialDiagnostics
+}
+
+// ReadFrame returns the next frame with a valid CRC. The data skipped before
+// a frame, e.g. line noise or a frame with a bad CRC, is counted once as a
+// communication error.
+func (r *rtuScanner) ReadFrame() ([]byte, error) {
+	for {
+		advance, frame, _ := ScanRTUFrames(r.buffer, false)
+		if skipped := advance - len(frame); skipped > 0 {
+			warninglog.Printf("skip serial data: %v", hex.EncodeToString(r.buffer[:skipped]))
+			if !r.skipping {
+				r.skipping = true
+				r.diag.count(busMessageCount)
+				r.diag.lineError(busCommunicationErrorCount)
+			}
+		}
+		r.buffer = r.buffer[advance:]
+		if frame != nil {
+			r.skipping = false
+			return frame, nil
+		}
+
+		chunk, ok := <-r.chunks
+		if !ok {
+			return nil, io.EOF
+		}
+		r.buffer = append(r.buffer, chunk.data...)
+	}
+}
+
+// warnUnknownLengths logs the function codes with a registered handler whose
+// requests can't be delimited by ScanRTUFrames. deviceMu must not be held.
+func (s *Server) warnUnknownLengths(listener string) {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
+	for code := 0; code < 256; code++ {
+		function := uint8(code)
+		if rtuRequestLength([]byte{idmin, function}) >= 0 {
+			continue
+		}
+		registered := s.function[function] != nil
+		for _, device := range s.Devices {
+			registered = registered || device.function[function] != nil
+		}
+		if registered {
+			warninglog.Printf("%v: the length of function %v requests is unknown, they are skipped\n", listener, function)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package mbserver
 
-import "testing"
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"testing/iotest"
+)
 
 func TestNewRTUFrame(t *testing.T) {
 	frame, err := NewRTUFrame([]byte{0x01, 0x04, 0x02, 0xFF, 0xFF, 0xB8, 0x80})
@@ -49,3 +54,59 @@ func TestRTUFrameBytes(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestScanRTUFrames(t *testing.T) {
+	readHolding := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a}
+	writeMultiple := (&RTUFrame{Address: 2, Function: 16, Data: []byte{0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x03, 0x00, 0x04}}).Bytes()
+	readStatus := (&RTUFrame{Address: 3, Function: 7}).Bytes()
+
+	// Line noise, three frames in one read and a truncated frame.
+	stream := []byte{0xff, 0x00}
+	stream = append(stream, readHolding...)
+	stream = append(stream, writeMultiple...)
+	stream = append(stream, readStatus...)
+	stream = append(stream, readHolding[:5]...)
+
+	scanner := bufio.NewScanner(iotest.HalfReader(bytes.NewReader(stream)))
+	scanner.Split(ScanRTUFrames)
+
+	var got [][]byte
+	for scanner.Scan() {
+		got = append(got, append([]byte{}, scanner.Bytes()...))
+	}
+
+	expect := [][]byte{readHolding, writeMultiple, readStatus}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestScanRTUFramesIncomplete(t *testing.T) {
+	advance, token, err := ScanRTUFrames([]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00}, false)
+	if advance != 0 || token != nil || err != nil {
+		t.Errorf("expected 0, nil, nil, got %v, %v, %v", advance, token, err)
+	}
+}
+
+func TestScanRTUFramesQueryData(t *testing.T) {
+	readHolding := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a}
+	queryData := (&RTUFrame{Address: 1, Function: 8, Data: []byte{0x00, 0x00, 1, 2, 3, 4, 5, 6}}).Bytes()
+	restart := (&RTUFrame{Address: 1, Function: 8, Data: []byte{0x00, 0x01, 0x00, 0x00}}).Bytes()
+
+	stream := append([]byte{}, queryData...)
+	stream = append(stream, restart...)
+	stream = append(stream, readHolding...)
+
+	scanner := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(stream)))
+	scanner.Split(ScanRTUFrames)
+
+	var got [][]byte
+	for scanner.Scan() {
+		got = append(got, append([]byte{}, scanner.Bytes()...))
+	}
+
+	expect := [][]byte{queryData, restart, readHolding}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
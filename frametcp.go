@@ -3,6 +3,14 @@ package mbserver
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
+)
+
+const (
+	// tcpHeaderSize is the size of the MBAP header including the unit identifier.
+	tcpHeaderSize = 7
+	// tcpMaxLength is the largest MBAP length field: unit identifier plus a 253 byte PDU.
+	tcpMaxLength = 254
 )
 
 // TCPFrame is the Modbus TCP frame.
@@ -17,9 +25,9 @@ type TCPFrame struct {
 
 // NewTCPFrame converts a packet to a Modbus TCP frame.
 func NewTCPFrame(packet []byte) (*TCPFrame, error) {
-	// Check if the packet is too short.
-	if len(packet) < 9 {
-		return nil, fmt.Errorf("TCP Frame error: packet less than 9 bytes")
+	// Check if the packet is too short, requests like Read Exception Status have no data.
+	if len(packet) < 8 {
+		return nil, fmt.Errorf("TCP Frame error: packet less than 8 bytes")
 	}
 
 	frame := &TCPFrame{
@@ -39,6 +47,39 @@ func NewTCPFrame(packet []byte) (*TCPFrame, error) {
 	return frame, nil
 }
 
+// ReadTCPFrame reads exactly one Modbus TCP frame (ADU) from a byte stream.
+// The MBAP header is read first and its Length field determines how many bytes
+// belong to the frame, so frames split across several TCP segments as well as
+// pipelined frames are handled. io.EOF is returned if the stream ends before
+// the first byte of a frame.
+func ReadTCPFrame(r io.Reader) (*TCPFrame, error) {
+	header := make([]byte, tcpHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if protocol := binary.BigEndian.Uint16(header[2:4]); protocol != 0 {
+		return nil, fmt.Errorf("TCP Frame error: unsupported protocol identifier %v", protocol)
+	}
+
+	// The length field counts the unit identifier, which is part of the header.
+	length := int(binary.BigEndian.Uint16(header[4:6]))
+	if length < 2 || length > tcpMaxLength {
+		return nil, fmt.Errorf("TCP Frame error: invalid length %v", length)
+	}
+
+	packet := make([]byte, tcpHeaderSize-1+length)
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[tcpHeaderSize:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return NewTCPFrame(packet)
+}
+
 // Copy the TCPFrame.
 func (frame *TCPFrame) Copy() Framer {
 	copy := *frame
@@ -1,6 +1,11 @@
 package mbserver
 
-import "testing"
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
 
 func TestNewTCPFrame(t *testing.T) {
 	frame, err := NewRTUFrame([]byte{0x15, 0x04, 0x02, 0xFF, 0xFF, 0x88, 0x83})
@@ -49,3 +54,65 @@ func TestTCPFrameBytes(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestReadTCPFrame(t *testing.T) {
+	// Two pipelined requests, delivered one byte per read.
+	stream := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x02, 0x04, 0x00, 0x10, 0x00, 0x01,
+	}
+	reader := iotest.OneByteReader(bytes.NewReader(stream))
+
+	for _, expect := range []TCPFrame{
+		{TransactionIdentifier: 1, Length: 6, Device: 1, Function: 3, Data: []byte{0x00, 0x00, 0x00, 0x02}},
+		{TransactionIdentifier: 2, Length: 6, Device: 2, Function: 4, Data: []byte{0x00, 0x10, 0x00, 0x01}},
+	} {
+		got, err := ReadTCPFrame(reader)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if !isEqual(expect, *got) {
+			t.Errorf("expected %v, got %v", expect, *got)
+		}
+	}
+
+	if _, err := ReadTCPFrame(reader); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestReadTCPFrameNoData(t *testing.T) {
+	// Report Server ID has a function code and no data.
+	stream := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01, 0x11}
+	got, err := ReadTCPFrame(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := TCPFrame{TransactionIdentifier: 1, Length: 2, Device: 1, Function: 17, Data: []byte{}}
+	if !isEqual(expect, *got) {
+		t.Errorf("expected %v, got %v", expect, *got)
+	}
+	if !isEqual(stream, got.Bytes()) {
+		t.Errorf("expected %v, got %v", stream, got.Bytes())
+	}
+}
+
+func TestReadTCPFrameTruncated(t *testing.T) {
+	stream := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00}
+	if _, err := ReadTCPFrame(bytes.NewReader(stream)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestReadTCPFrameBadHeader(t *testing.T) {
+	for _, stream := range [][]byte{
+		// Protocol identifier is not Modbus.
+		{0x00, 0x01, 0x00, 0x01, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02},
+		// Length exceeds the maximum ADU size.
+		{0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02},
+	} {
+		if _, err := ReadTCPFrame(bytes.NewReader(stream)); err == nil {
+			t.Errorf("expected error not nil, got %v", err)
+		}
+	}
+}
@@ -5,11 +5,27 @@ import (
 	"encoding/hex"
 )
 
+// Limits of the quantity of a request defined by the Modbus Application Protocol specification.
+const (
+	maxReadBits       = 2000
+	maxReadRegisters  = 125
+	maxWriteBits      = 1968
+	maxWriteRegisters = 123
+)
+
 // ReadCoils function 1, reads coils from internal memory.
 func ReadCoils(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 4 {
+		infolog.Printf("ReadCoils from Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	device := frame.GetDevice()
 
+	if numRegs < 1 || numRegs > maxReadBits {
+		infolog.Printf("ReadCoils from Device %v, Address %v, quantity %v >> Exception: IllegalDataValue\n", device, register, numRegs)
+		return []byte{}, IllegalDataValue
+	}
 	if endRegister > 65536 {
 		infolog.Printf("ReadCoils from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, Registeraddress: %v\n", device, register, numRegs, endRegister)
 		return []byte{}, IllegalDataAddress
@@ -17,28 +33,31 @@ func ReadCoils(s *Server, frame Framer) ([]byte, Exception) {
 
 	debuglog.Printf("ReadCoils from Device %v, Address %v, quantity %v\n", device, register, numRegs)
 
-	dataSize := numRegs / 8
-	if (numRegs % 8) != 0 {
-		dataSize++
-	}
-	data := make([]byte, 1+dataSize)
-	data[0] = byte(dataSize)
-	for i, value := range s.Devices[device].Coils[register:endRegister] {
-		if value != 0 {
-			shift := uint(i) % 8
-			data[1+i/8] |= byte(1 << shift)
-		}
+	values, exception := s.readBits(device, CoilTable, uint16(register), numRegs)
+	if exception != Success {
+		infolog.Printf("ReadCoils from Device %v, Address %v, quantity %v >> Exception: %v\n", device, register, numRegs, exception)
+		return []byte{}, exception
 	}
 
+	data := packBits(values)
+
 	tracelog.Printf("response %v\n", hex.EncodeToString(data))
 	return data, Success
 }
 
 // ReadDiscreteInputs function 2, reads discrete inputs from internal memory.
 func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 4 {
+		infolog.Printf("ReadDiscreteInputs from Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	device := frame.GetDevice()
 
+	if numRegs < 1 || numRegs > maxReadBits {
+		infolog.Printf("ReadDiscreteInputs from Device %v, Address %v, quantity %v >> Exception: IllegalDataValue\n", device, register, numRegs)
+		return []byte{}, IllegalDataValue
+	}
 	if endRegister > 65536 {
 		infolog.Printf("ReadDiscreteInputs from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, Registeraddress: %v\n", device, register, numRegs, endRegister)
 		return []byte{}, IllegalDataAddress
@@ -46,44 +65,61 @@ func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, Exception) {
 
 	debuglog.Printf("ReadDiscreteInputs from Device %v, Address %v, quantity %v\n", device, register, numRegs)
 
-	dataSize := numRegs / 8
-	if (numRegs % 8) != 0 {
-		dataSize++
-	}
-	data := make([]byte, 1+dataSize)
-	data[0] = byte(dataSize)
-	for i, value := range s.Devices[device].DiscreteInputs[register:endRegister] {
-		if value != 0 {
-			shift := uint(i) % 8
-			data[1+i/8] |= byte(1 << shift)
-		}
+	values, exception := s.readBits(device, DiscreteInputTable, uint16(register), numRegs)
+	if exception != Success {
+		infolog.Printf("ReadDiscreteInputs from Device %v, Address %v, quantity %v >> Exception: %v\n", device, register, numRegs, exception)
+		return []byte{}, exception
 	}
 
+	data := packBits(values)
+
 	tracelog.Printf("response %v\n", hex.EncodeToString(data))
 	return data, Success
 }
 
 // ReadHoldingRegisters function 3, reads holding registers from internal memory.
 func ReadHoldingRegisters(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 4 {
+		infolog.Printf("ReadHoldingRegisters from Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	device := frame.GetDevice()
 
+	if numRegs < 1 || numRegs > maxReadRegisters {
+		infolog.Printf("ReadHoldingRegisters from Device %v, Address %v, quantity %v >> Exception: IllegalDataValue\n", device, register, numRegs)
+		return []byte{}, IllegalDataValue
+	}
 	if endRegister > 65536 {
 		infolog.Printf("ReadHoldingRegisters from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, Registeraddress: %v\n", device, register, numRegs, endRegister)
 		return []byte{}, IllegalDataAddress
 	}
 
 	debuglog.Printf("ReadHoldingRegisters from Device %v, Address %v, quantity %v\n", device, register, numRegs)
-	r := append([]byte{byte(numRegs * 2)}, Uint16ToBytes(s.Devices[device].HoldingRegisters[register:endRegister])...)
+	values, exception := s.readRegisters(device, HoldingRegisterTable, uint16(register), numRegs)
+	if exception != Success {
+		infolog.Printf("ReadHoldingRegisters from Device %v, Address %v, quantity %v >> Exception: %v\n", device, register, numRegs, exception)
+		return []byte{}, exception
+	}
+
+	r := append([]byte{byte(numRegs * 2)}, Uint16ToBytes(values)...)
 	tracelog.Printf("response %v\n", hex.EncodeToString(r))
 	return r, Success
 }
 
 // ReadInputRegisters function 4, reads input registers from internal memory.
 func ReadInputRegisters(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 4 {
+		infolog.Printf("ReadInputRegisters from Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	device := frame.GetDevice()
 
+	if numRegs < 1 || numRegs > maxReadRegisters {
+		infolog.Printf("ReadInputRegisters from Device %v, Address %v, quantity %v >> Exception: IllegalDataValue\n", device, register, numRegs)
+		return []byte{}, IllegalDataValue
+	}
 	if endRegister > 65536 {
 		infolog.Printf("ReadInputRegisters from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, Registeraddress: %v\n", device, register, numRegs, endRegister)
 		return []byte{}, IllegalDataAddress
@@ -91,24 +127,38 @@ func ReadInputRegisters(s *Server, frame Framer) ([]byte, Exception) {
 
 	debuglog.Printf("ReadInputRegisters from Device %v, Address %v, quantity %v\n", device, register, numRegs)
 
-	r := append([]byte{byte(numRegs * 2)}, Uint16ToBytes(s.Devices[device].InputRegisters[register:endRegister])...)
+	values, exception := s.readRegisters(device, InputRegisterTable, uint16(register), numRegs)
+	if exception != Success {
+		infolog.Printf("ReadInputRegisters from Device %v, Address %v, quantity %v >> Exception: %v\n", device, register, numRegs, exception)
+		return []byte{}, exception
+	}
+
+	r := append([]byte{byte(numRegs * 2)}, Uint16ToBytes(values)...)
 	tracelog.Printf("Response %v\n", r)
 	return r, Success
 }
 
 // WriteSingleCoil function 5, write a coil to internal memory.
 func WriteSingleCoil(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 4 {
+		infolog.Printf("WriteSingleCoil to Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
 	device := frame.GetDevice()
 
-	// TODO Should we use 0 for off and 65,280 (FF00 in hexadecimal) for on?
-	if value != 0 {
-		value = 1
+	// The value is 0x0000 for off and 0xFF00 for on.
+	if value != 0 && value != 0xff00 {
+		infolog.Printf("WriteSingleCoil to Device %v, Address %v, value %v >> Exception: IllegalDataValue\n", device, register, value)
+		return []byte{}, IllegalDataValue
 	}
 
 	debuglog.Printf("WriteSingleCoil to Device %v, Address %v, value %v\n", device, register, value)
 
-	s.Devices[device].Coils[register] = byte(value)
+	if exception := s.writeBits(device, CoilTable, uint16(register), []bool{value != 0}); exception != Success {
+		infolog.Printf("WriteSingleCoil to Device %v, Address %v >> Exception: %v\n", device, register, exception)
+		return []byte{}, exception
+	}
 	r := frame.GetData()[0:4]
 	tracelog.Printf("response %v\n", hex.EncodeToString(r))
 	return r, Success
@@ -116,12 +166,19 @@ func WriteSingleCoil(s *Server, frame Framer) ([]byte, Exception) {
 
 // WriteHoldingRegister function 6, write a holding register to internal memory.
 func WriteHoldingRegister(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 4 {
+		infolog.Printf("WriteHoldingRegister to Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
 	device := frame.GetDevice()
 
 	debuglog.Printf("WriteHoldingRegister to Device %v, Address %v, value %v\n", device, register, value)
 
-	s.Devices[device].HoldingRegisters[register] = value
+	if exception := s.writeRegisters(device, HoldingRegisterTable, uint16(register), []uint16{value}); exception != Success {
+		infolog.Printf("WriteHoldingRegister to Device %v, Address %v >> Exception: %v\n", device, register, exception)
+		return []byte{}, exception
+	}
 	r := frame.GetData()[0:4]
 	tracelog.Printf("response %v\n", hex.EncodeToString(r))
 	return r, Success
@@ -129,11 +186,20 @@ func WriteHoldingRegister(s *Server, frame Framer) ([]byte, Exception) {
 
 // WriteMultipleCoils function 15, writes holding registers to internal memory.
 func WriteMultipleCoils(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 5 {
+		infolog.Printf("WriteMultipleCoils to Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	device := frame.GetDevice()
 
 	valueBytes := frame.GetData()[5:]
 
+	byteCount := (numRegs + 7) / 8
+	if numRegs < 1 || numRegs > maxWriteBits || int(frame.GetData()[4]) != byteCount || len(valueBytes) != byteCount {
+		infolog.Printf("WriteMultipleCoils from Device %v, Address %v, quantity %v, byte count %v >> Exception: IllegalDataValue\n", device, register, numRegs, frame.GetData()[4])
+		return []byte{}, IllegalDataValue
+	}
 	if endRegister > 65536 {
 		infolog.Printf("WriteMultipleCoils from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, Registeraddress: %v\n", device, register, numRegs, endRegister)
 		return []byte{}, IllegalDataAddress
@@ -141,25 +207,18 @@ func WriteMultipleCoils(s *Server, frame Framer) ([]byte, Exception) {
 
 	debuglog.Printf("WriteMultipleCoils to Device %v, Address %v, values %v\n", device, register, valueBytes)
 
-	// TODO This is not correct, bits and bytes do not always align
-	//if len(valueBytes)/2 != numRegs {
-	//	return []byte{}, &IllegalDataAddress
-	//}
-
-	bitCount := 0
-	for i, value := range valueBytes {
-		for bitPos := uint(0); bitPos < 8; bitPos++ {
-			s.Devices[device].Coils[register+(i*8)+int(bitPos)] = bitAtPosition(value, bitPos)
-			bitCount++
-			if bitCount >= numRegs {
-				break
-			}
-		}
-		if bitCount >= numRegs {
-			break
+	values := make([]bool, 0, numRegs)
+	for _, value := range valueBytes {
+		for bitPos := uint(0); bitPos < 8 && len(values) < numRegs; bitPos++ {
+			values = append(values, bitAtPosition(value, bitPos) != 0)
 		}
 	}
 
+	if exception := s.writeBits(device, CoilTable, uint16(register), values); exception != Success {
+		infolog.Printf("WriteMultipleCoils to Device %v, Address %v, quantity %v >> Exception: %v\n", device, register, numRegs, exception)
+		return []byte{}, exception
+	}
+
 	r := frame.GetData()[0:4]
 	tracelog.Printf("response %v\n", hex.EncodeToString(r))
 	return r, Success
@@ -167,25 +226,29 @@ func WriteMultipleCoils(s *Server, frame Framer) ([]byte, Exception) {
 
 // WriteHoldingRegisters function 16, writes holding registers to internal memory.
 func WriteHoldingRegisters(s *Server, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 5 {
+		infolog.Printf("WriteHoldingRegisters to Device %v >> Exception: IllegalDataValue, PDU length %v\n", frame.GetDevice(), len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	device := frame.GetDevice()
 	valueBytes := frame.GetData()[5:]
 
+	if numRegs < 1 || numRegs > maxWriteRegisters || int(frame.GetData()[4]) != 2*numRegs || len(valueBytes) != 2*numRegs {
+		infolog.Printf("WriteHoldingRegisters from Device %v, Address %v, quantity %v, byte count %v >> Exception: IllegalDataValue\n", device, register, numRegs, frame.GetData()[4])
+		return []byte{}, IllegalDataValue
+	}
 	if endRegister > 65536 {
 		infolog.Printf("WriteHoldingRegisters from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, Registeraddress: %v\n", device, register, numRegs, endRegister)
 		return []byte{}, IllegalDataAddress
 	}
-	if len(valueBytes)/2 != numRegs {
-		errorlog.Printf("WriteHoldingRegisters from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, len(valueBytes)/2 != numRegs : (%v != %v)\n", device, register, numRegs, len(valueBytes)/2, numRegs)
-		return []byte{}, IllegalDataAddress
-	}
 
 	debuglog.Printf("WriteHoldingRegisters to Device %v, Address %v, values %v\n", device, register, valueBytes)
 	// Copy data to memory
 	values := BytesToUint16(valueBytes)
-	if valuesUpdated := copy(s.Devices[device].HoldingRegisters[register:], values); valuesUpdated != numRegs {
-		errorlog.Printf("WriteHoldingRegisters from Device %v, Address %v, quantity %v >> Exception: IllegalDataAddress, valuesUpdated != numRegs: (%v != %v\n)", device, register, numRegs, valuesUpdated, numRegs)
-		return []byte{}, IllegalDataAddress
+	if exception := s.writeRegisters(device, HoldingRegisterTable, uint16(register), values); exception != Success {
+		infolog.Printf("WriteHoldingRegisters to Device %v, Address %v, quantity %v >> Exception: %v\n", device, register, numRegs, exception)
+		return []byte{}, exception
 	}
 
 	r := frame.GetData()[0:4]
@@ -213,6 +276,23 @@ func Uint16ToBytes(values []uint16) []byte {
 	return bytes
 }
 
+// packBits packs bits into bytes, least significant bit first, preceded by the byte count.
+func packBits(values []bool) []byte {
+	dataSize := len(values) / 8
+	if (len(values) % 8) != 0 {
+		dataSize++
+	}
+	data := make([]byte, 1+dataSize)
+	data[0] = byte(dataSize)
+	for i, value := range values {
+		if value {
+			shift := uint(i) % 8
+			data[1+i/8] |= byte(1 << shift)
+		}
+	}
+	return data
+}
+
 func bitAtPosition(value uint8, pos uint) uint8 {
 	return (value >> pos) & 0x01
 }
@@ -20,10 +20,8 @@ func TestReadCoils(t *testing.T) {
 	s := NewServer()
 	s.NewDevice(deviceid)
 	// Set the coil values
-	s.Devices[deviceid].Coils[10] = 1
-	s.Devices[deviceid].Coils[11] = 1
-	s.Devices[deviceid].Coils[17] = 1
-	s.Devices[deviceid].Coils[18] = 1
+	s.SetCoils(deviceid, 10, []bool{true, true})
+	s.SetCoils(deviceid, 17, []bool{true, true})
 
 	var frame TCPFrame
 	frame.TransactionIdentifier = 1
@@ -57,10 +55,8 @@ func TestReadDiscreteInputs(t *testing.T) {
 	s := NewServer()
 	s.NewDevice(deviceid)
 	// Set the discrete input values
-	s.Devices[deviceid].DiscreteInputs[0] = 1
-	s.Devices[deviceid].DiscreteInputs[7] = 1
-	s.Devices[deviceid].DiscreteInputs[8] = 1
-	s.Devices[deviceid].DiscreteInputs[9] = 1
+	s.SetDiscreteInputs(deviceid, 0, []bool{true})
+	s.SetDiscreteInputs(deviceid, 7, []bool{true, true, true})
 
 	var frame TCPFrame
 	frame.TransactionIdentifier = 1
@@ -92,9 +88,7 @@ func TestReadHoldingRegisters(t *testing.T) {
 	s := NewServer()
 	s.NewDevice(deviceid)
 
-	s.Devices[deviceid].HoldingRegisters[100] = 1
-	s.Devices[deviceid].HoldingRegisters[101] = 2
-	s.Devices[deviceid].HoldingRegisters[102] = 65535
+	s.SetHoldingRegisters(deviceid, 100, []uint16{1, 2, 65535})
 
 	var frame TCPFrame
 	frame.TransactionIdentifier = 1
@@ -123,9 +117,7 @@ func TestReadHoldingRegisters(t *testing.T) {
 func TestReadInputRegisters(t *testing.T) {
 	s := NewServer()
 
-	s.Devices[1].InputRegisters[200] = 1
-	s.Devices[1].InputRegisters[201] = 2
-	s.Devices[1].InputRegisters[202] = 65535
+	s.SetInputRegisters(1, 200, []uint16{1, 2, 65535})
 
 	var frame TCPFrame
 	frame.TransactionIdentifier = 1
@@ -160,7 +152,7 @@ func TestWriteSingleCoil(t *testing.T) {
 	frame.Length = 12
 	frame.Device = 1
 	frame.Function = 5
-	SetDataWithRegisterAndNumber(&frame, 65535, 1024)
+	SetDataWithRegisterAndNumber(&frame, 65535, 0xff00)
 
 	var req Request
 	req.frame = &frame
@@ -170,8 +162,8 @@ func TestWriteSingleCoil(t *testing.T) {
 		t.Errorf("expected Success, got %v", exception.String())
 		t.FailNow()
 	}
-	expect := 1
-	got := s.Devices[1].Coils[65535]
+	expect := []bool{true}
+	got, _ := s.Coils(1, 65535, 1)
 	if !isEqual(expect, got) {
 		t.Errorf("expected %v, got %v\n", expect, got)
 	}
@@ -197,8 +189,8 @@ func TestWriteHoldingRegister(t *testing.T) {
 		t.Errorf("expected Success, got %v", exception.String())
 		t.FailNow()
 	}
-	expect := 6
-	got := s.Devices[1].HoldingRegisters[5]
+	expect := []uint16{6}
+	got, _ := s.HoldingRegisters(1, 5, 1)
 	if !isEqual(expect, got) {
 		t.Errorf("expected %v, got %v\n", expect, got)
 	}
@@ -224,8 +216,8 @@ func TestWriteMultipleCoils(t *testing.T) {
 		t.Errorf("expected Success, got %v", exception.String())
 		t.FailNow()
 	}
-	expect := []byte{1, 1}
-	got := s.Devices[1].Coils[1:3]
+	expect := []bool{true, true}
+	got, _ := s.Coils(1, 1, 2)
 	if !isEqual(expect, got) {
 		t.Errorf("expected %v, got %v\n", expect, got)
 	}
@@ -254,7 +246,7 @@ func TestWriteHoldingRegisters(t *testing.T) {
 		t.FailNow()
 	}
 	expect := []uint16{3, 4}
-	got := s.Devices[deviceid].HoldingRegisters[1:3]
+	got, _ := s.HoldingRegisters(deviceid, 1, 2)
 	if !isEqual(expect, got) {
 		t.Errorf("expected %v, got %v\n", expect, got)
 	}
@@ -340,3 +332,83 @@ func TestOutOfBounds(t *testing.T) {
 		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
 	}
 }
+
+func TestQuantityLimits(t *testing.T) {
+	s := NewServer()
+
+	for _, test := range []struct {
+		function byte
+		address  uint16
+		number   uint16
+		values   []byte
+		expect   Exception
+	}{
+		{1, 0, 0, nil, IllegalDataValue},
+		{1, 0, 2000, nil, Success},
+		{1, 0, 2001, nil, IllegalDataValue},
+		{2, 65535, 2000, nil, IllegalDataAddress},
+		{2, 0, 2001, nil, IllegalDataValue},
+		{3, 0, 125, nil, Success},
+		{3, 0, 126, nil, IllegalDataValue},
+		{3, 0, 200, nil, IllegalDataValue},
+		{4, 0, 0, nil, IllegalDataValue},
+		{4, 65535, 2, nil, IllegalDataAddress},
+		{5, 0, 0x0000, nil, Success},
+		{5, 0, 0x0001, nil, IllegalDataValue},
+		{15, 0, 9, []byte{2, 0xff, 0x01}, Success},
+		{15, 0, 9, []byte{1, 0xff}, IllegalDataValue},
+		{15, 0, 9, []byte{2, 0xff}, IllegalDataValue},
+		{15, 0, 0, []byte{0}, IllegalDataValue},
+		{15, 0, 1969, append([]byte{247}, make([]byte, 247)...), IllegalDataValue},
+		{15, 65535, 2, []byte{1, 0x03}, IllegalDataAddress},
+		{16, 0, 2, []byte{4, 0, 1, 0, 2}, Success},
+		{16, 0, 2, []byte{2, 0, 1}, IllegalDataValue},
+		{16, 0, 2, []byte{4, 0, 1}, IllegalDataValue},
+		{16, 0, 124, append([]byte{248}, make([]byte, 248)...), IllegalDataValue},
+		{16, 65535, 2, []byte{4, 0, 1, 0, 2}, IllegalDataAddress},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function}
+		SetDataWithRegisterAndNumber(frame, test.address, test.number)
+		frame.Data = append(frame.Data, test.values...)
+		response := s.handle(&Request{frame: frame})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("function %v address %v quantity %v: expected %v, got %v", test.function, test.address, test.number, test.expect, got)
+		}
+	}
+}
+
+func TestMalformedRequests(t *testing.T) {
+	s := NewServer()
+	s.RegisterFunctionHandler(100, func(s *Server, frame Framer) ([]byte, Exception) {
+		return frame.GetData()[10:], Success
+	})
+
+	for _, test := range []struct {
+		function byte
+		data     []byte
+		expect   Exception
+	}{
+		{1, []byte{}, IllegalDataValue},
+		{2, []byte{0, 0, 0}, IllegalDataValue},
+		{3, []byte{0, 0, 0, 1, 0}, IllegalDataValue},
+		{4, []byte{0}, IllegalDataValue},
+		{5, []byte{0, 0}, IllegalDataValue},
+		{6, []byte{0, 0, 0}, IllegalDataValue},
+		{15, []byte{0, 0, 0, 1}, IllegalDataValue},
+		{16, []byte{0, 0, 0, 1}, IllegalDataValue},
+		{100, []byte{0, 0, 0, 1}, SlaveDeviceFailure},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function, Data: test.data}
+		response := s.handle(&Request{frame: frame})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("function %v data %v: expected %v, got %v", test.function, test.data, test.expect, got)
+		}
+	}
+
+	// The server keeps serving after a panic.
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	if got := GetException(s.handle(&Request{frame: frame})); got != Success {
+		t.Errorf("expected %v, got %v", Success, got)
+	}
+}
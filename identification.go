@@ -0,0 +1,157 @@
+package mbserver
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DeviceObject is the id of a device identification object returned by
+// ReadDeviceIdentification.
+type DeviceObject byte
+
+// Device identification objects. The basic objects are always returned, empty if not set.
+// The objects 0x80 to 0xFF are private objects of the extended category.
+const (
+	VendorName          DeviceObject = 0x00
+	ProductCode         DeviceObject = 0x01
+	MajorMinorRevision  DeviceObject = 0x02
+	VendorURL           DeviceObject = 0x03
+	ProductName         DeviceObject = 0x04
+	ModelName           DeviceObject = 0x05
+	UserApplicationName DeviceObject = 0x06
+)
+
+// meiReadDeviceIdentification is the MEI type of Read Device Identification.
+const meiReadDeviceIdentification = 0x0e
+
+// Read device id codes of Read Device Identification.
+const (
+	basicStreamAccess    = 0x01
+	regularStreamAccess  = 0x02
+	extendedStreamAccess = 0x03
+	individualAccess     = 0x04
+)
+
+// maxObjectsSize is the space for the objects in a response, the PDU size of 253 bytes
+// minus the function code, the MEI type and the five bytes of the response header.
+const maxObjectsSize = 246
+
+// SetDeviceIdentification sets the value of the identification object of device id
+// returned by Read Device Identification (function 43 / MEI type 14).
+// The objects 0x07 to 0x7F are reserved.
+func (s *Server) SetDeviceIdentification(id byte, object DeviceObject, value string) error {
+	return s.withDevice(id, func(device *Device) error {
+		if object > UserApplicationName && object < 0x80 {
+			return fmt.Errorf("mbserver: device identification object %v is reserved", object)
+		}
+		if len(value) > maxObjectsSize-2 {
+			return fmt.Errorf("mbserver: device identification object %v exceeds %v bytes", object, maxObjectsSize-2)
+		}
+		if device.identification == nil {
+			device.identification = map[DeviceObject]string{}
+		}
+		device.identification[object] = value
+		return nil
+	})
+}
+
+// identificationObjects returns the ids of the objects of the device up to last in ascending order.
+func (d *Device) identificationObjects(last DeviceObject) []DeviceObject {
+	objects := []DeviceObject{VendorName, ProductCode, MajorMinorRevision}
+	for object := range d.identification {
+		if object > MajorMinorRevision && object <= last {
+			objects = append(objects, object)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i] < objects[j] })
+	return objects
+}
+
+// conformityLevel returns the highest category of the objects of the device,
+// individual access is always supported.
+func (d *Device) conformityLevel() byte {
+	level := byte(basicStreamAccess)
+	for object := range d.identification {
+		switch {
+		case object >= 0x80:
+			level = extendedStreamAccess
+		case object > MajorMinorRevision && level < regularStreamAccess:
+			level = regularStreamAccess
+		}
+	}
+	return 0x80 | level
+}
+
+// ReadDeviceIdentification function 43 with MEI type 14, returns the identification
+// objects of a device. The stream access returns the objects of the requested category
+// and the lower categories starting at the requested object. If they don't fit into one
+// response, the master continues with the next object id of the response.
+// The individual access returns one object.
+func ReadDeviceIdentification(s *Server, request *Request) ([]byte, Exception) {
+	frame := request.Frame()
+	device := frame.GetDevice()
+	data := frame.GetData()
+
+	if len(data) < 1 || data[0] != meiReadDeviceIdentification {
+		infolog.Printf("ReadDeviceIdentification from Device %v >> Exception: IllegalFunction, MEI type %v\n", device, data)
+		return []byte{}, IllegalFunction
+	}
+	if len(data) != 3 {
+		infolog.Printf("ReadDeviceIdentification from Device %v >> Exception: IllegalDataValue, PDU length %v\n", device, len(data))
+		return []byte{}, IllegalDataValue
+	}
+
+	code := data[1]
+	object := DeviceObject(data[2])
+	debuglog.Printf("ReadDeviceIdentification from Device %v, code %v, object %v\n", device, code, object)
+
+	d := s.Devices[device]
+	response := []byte{meiReadDeviceIdentification, code, d.conformityLevel(), 0x00, 0x00, 0x00}
+
+	var objects []DeviceObject
+	switch code {
+	case basicStreamAccess:
+		objects = d.identificationObjects(MajorMinorRevision)
+	case regularStreamAccess:
+		objects = d.identificationObjects(0x7f)
+	case extendedStreamAccess:
+		objects = d.identificationObjects(0xff)
+	case individualAccess:
+		if _, ok := d.identification[object]; !ok && object > MajorMinorRevision {
+			infolog.Printf("ReadDeviceIdentification from Device %v, object %v >> Exception: IllegalDataAddress\n", device, object)
+			return []byte{}, IllegalDataAddress
+		}
+		response[5] = 1
+		return appendObject(response, object, d.identification[object]), Success
+	default:
+		infolog.Printf("ReadDeviceIdentification from Device %v >> Exception: IllegalDataValue, read device id code %v\n", device, code)
+		return []byte{}, IllegalDataValue
+	}
+
+	// An unknown object restarts the stream at the first object.
+	start := 0
+	for i, o := range objects {
+		if o == object {
+			start = i
+		}
+	}
+
+	size := 0
+	for _, o := range objects[start:] {
+		value := d.identification[o]
+		if size+2+len(value) > maxObjectsSize {
+			response[3] = 0xff
+			response[4] = byte(o)
+			break
+		}
+		size += 2 + len(value)
+		response[5]++
+		response = appendObject(response, o, value)
+	}
+	return response, Success
+}
+
+func appendObject(data []byte, object DeviceObject, value string) []byte {
+	data = append(data, byte(object), byte(len(value)))
+	return append(data, value...)
+}
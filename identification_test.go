@@ -0,0 +1,67 @@
+package mbserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadDeviceIdentification(t *testing.T) {
+	s := NewServer()
+	s.NewDevice(2)
+	s.SetDeviceIdentification(1, VendorName, "ACME")
+	s.SetDeviceIdentification(1, ProductCode, "PC")
+	s.SetDeviceIdentification(1, ModelName, "M1")
+	s.SetDeviceIdentification(1, 0x80, strings.Repeat("x", 200))
+	s.SetDeviceIdentification(1, 0x81, strings.Repeat("y", 100))
+
+	for i, test := range []struct {
+		device   byte
+		data     []byte
+		expect   Exception
+		response []byte
+	}{
+		// Basic stream access of a device without objects.
+		{2, []byte{0x0e, 1, 0}, Success, []byte{0x0e, 1, 0x81, 0, 0, 3, 0, 0, 1, 0, 2, 0}},
+		{1, []byte{0x0e, 1, 0}, Success, []byte{0x0e, 1, 0x83, 0, 0, 3, 0, 4, 'A', 'C', 'M', 'E', 1, 2, 'P', 'C', 2, 0}},
+		// An unknown object restarts at the first object.
+		{1, []byte{0x0e, 2, 0x04}, Success, []byte{0x0e, 2, 0x83, 0, 0, 4, 0, 4, 'A', 'C', 'M', 'E', 1, 2, 'P', 'C', 2, 0, 5, 2, 'M', '1'}},
+		{1, []byte{0x0e, 2, 0x05}, Success, []byte{0x0e, 2, 0x83, 0, 0, 1, 5, 2, 'M', '1'}},
+		{1, []byte{0x0e, 4, 0x05}, Success, []byte{0x0e, 4, 0x83, 0, 0, 1, 5, 2, 'M', '1'}},
+		{1, []byte{0x0e, 4, 0x02}, Success, []byte{0x0e, 4, 0x83, 0, 0, 1, 2, 0}},
+		{1, []byte{0x0e, 4, 0x06}, IllegalDataAddress, nil},
+		{1, []byte{0x0e, 5, 0x00}, IllegalDataValue, nil},
+		{1, []byte{0x0e, 1}, IllegalDataValue, nil},
+		{1, []byte{0x0d, 1, 0}, IllegalFunction, nil},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: test.device, Function: 43, Data: test.data}
+		response := s.handle(&Request{frame: frame, Transport: TransportTCP})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("%v: expected %v, got %v", i, test.expect, got)
+		}
+		if got := response.GetData(); test.expect == Success && !isEqual(test.response, got) {
+			t.Errorf("%v: expected %v, got %v", i, test.response, got)
+		}
+	}
+
+	// The extended stream doesn't fit into one response and continues with object 0x81.
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 43, Data: []byte{0x0e, 3, 0}}
+	data := s.handle(&Request{frame: frame, Transport: TransportTCP}).GetData()
+	if expect := []byte{0x0e, 3, 0x83, 0xff, 0x81, 5}; !isEqual(expect, data[:6]) {
+		t.Errorf("expected %v, got %v", expect, data[:6])
+	}
+	frame.Data = []byte{0x0e, 3, 0x81}
+	data = s.handle(&Request{frame: frame, Transport: TransportTCP}).GetData()
+	if expect := []byte{0x0e, 3, 0x83, 0, 0, 1, 0x81, 100}; !isEqual(expect, data[:8]) {
+		t.Errorf("expected %v, got %v", expect, data[:8])
+	}
+
+	if err := s.SetDeviceIdentification(1, 0x07, ""); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.SetDeviceIdentification(3, VendorName, ""); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.SetDeviceIdentification(1, 0x82, strings.Repeat("z", 245)); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
@@ -31,13 +31,13 @@ func TestModbusRTU(t *testing.T) {
 
 	// Server
 	s := NewServer()
-	err = s.ListenRTU(&framereader.Config{
+	err = s.ListenRTUWithBaud(&framereader.Config{
 		Address:  "ttyFOO",
 		BaudRate: 115200,
 		DataBits: 8,
 		StopBits: 1,
 		Parity:   "N",
-		Timeout:  10 * time.Second})
+		Timeout:  10 * time.Second}, 115200)
 	if err != nil {
 		t.Fatalf("failed to listen, got %v\n", err)
 	}
@@ -0,0 +1,191 @@
+package mbserver
+
+import "fmt"
+
+// Memory gives the application access to the coils, discrete inputs, holding registers
+// and input registers of the devices. It is only valid inside the function passed to
+// Server.Update, where the access is serialized with the request handling.
+type Memory struct {
+	s *Server
+}
+
+// Update runs fn with exclusive access to the device memory. No request is
+// handled while fn runs, so all changes made by fn are seen atomically by the
+// masters, e.g. both registers of a 32-bit value.
+//
+// Function handlers, middleware, read hooks and validators run while the request
+// handling holds the device memory. They must not call Update, the accessors like
+// SetHoldingRegisters or the device configuration methods like NewDevice and OnRead,
+// the call would deadlock the server. Read hooks use their Memory argument instead,
+// function handlers and middleware access s.Devices directly.
+func (s *Server) Update(fn func(m Memory) error) error {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+	return fn(Memory{s})
+}
+
+// checkRange returns an error if the range exceeds the address space of a table.
+func checkRange(address uint16, quantity int) error {
+	if quantity < 0 || int(address)+quantity > 65536 {
+		return fmt.Errorf("mbserver: illegal data address %v, quantity %v", address, quantity)
+	}
+	return nil
+}
+
+// exceptionError converts an Exception of a Store to an error.
+func exceptionError(exception Exception) error {
+	if exception != Success {
+		return fmt.Errorf("mbserver: %v", exception)
+	}
+	return nil
+}
+
+func (m Memory) readBits(id byte, table Table, address uint16, quantity int) ([]bool, error) {
+	device, err := m.s.device(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRange(address, quantity); err != nil {
+		return nil, err
+	}
+	values, exception := device.ReadBits(table, address, quantity)
+	return values, exceptionError(exception)
+}
+
+func (m Memory) writeBits(id byte, table Table, address uint16, values []bool) error {
+	device, err := m.s.device(id)
+	if err != nil {
+		return err
+	}
+	if err := checkRange(address, len(values)); err != nil {
+		return err
+	}
+	return exceptionError(device.WriteBits(table, address, values))
+}
+
+func (m Memory) readRegisters(id byte, table Table, address uint16, quantity int) ([]uint16, error) {
+	device, err := m.s.device(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRange(address, quantity); err != nil {
+		return nil, err
+	}
+	values, exception := device.ReadRegisters(table, address, quantity)
+	return values, exceptionError(exception)
+}
+
+func (m Memory) writeRegisters(id byte, table Table, address uint16, values []uint16) error {
+	device, err := m.s.device(id)
+	if err != nil {
+		return err
+	}
+	if err := checkRange(address, len(values)); err != nil {
+		return err
+	}
+	return exceptionError(device.WriteRegisters(table, address, values))
+}
+
+// Coils returns quantity coils of device id starting at address.
+func (m Memory) Coils(id byte, address uint16, quantity int) ([]bool, error) {
+	return m.readBits(id, CoilTable, address, quantity)
+}
+
+// SetCoils sets the coils of device id starting at address.
+func (m Memory) SetCoils(id byte, address uint16, values []bool) error {
+	return m.writeBits(id, CoilTable, address, values)
+}
+
+// DiscreteInputs returns quantity discrete inputs of device id starting at address.
+func (m Memory) DiscreteInputs(id byte, address uint16, quantity int) ([]bool, error) {
+	return m.readBits(id, DiscreteInputTable, address, quantity)
+}
+
+// SetDiscreteInputs sets the discrete inputs of device id starting at address.
+func (m Memory) SetDiscreteInputs(id byte, address uint16, values []bool) error {
+	return m.writeBits(id, DiscreteInputTable, address, values)
+}
+
+// HoldingRegisters returns quantity holding registers of device id starting at address.
+func (m Memory) HoldingRegisters(id byte, address uint16, quantity int) ([]uint16, error) {
+	return m.readRegisters(id, HoldingRegisterTable, address, quantity)
+}
+
+// SetHoldingRegisters sets the holding registers of device id starting at address.
+func (m Memory) SetHoldingRegisters(id byte, address uint16, values []uint16) error {
+	return m.writeRegisters(id, HoldingRegisterTable, address, values)
+}
+
+// InputRegisters returns quantity input registers of device id starting at address.
+func (m Memory) InputRegisters(id byte, address uint16, quantity int) ([]uint16, error) {
+	return m.readRegisters(id, InputRegisterTable, address, quantity)
+}
+
+// SetInputRegisters sets the input registers of device id starting at address.
+func (m Memory) SetInputRegisters(id byte, address uint16, values []uint16) error {
+	return m.writeRegisters(id, InputRegisterTable, address, values)
+}
+
+// Coils returns quantity coils of device id starting at address.
+func (s *Server) Coils(id byte, address uint16, quantity int) (values []bool, err error) {
+	err = s.Update(func(m Memory) error {
+		values, err = m.Coils(id, address, quantity)
+		return err
+	})
+	return values, err
+}
+
+// SetCoils sets the coils of device id starting at address.
+func (s *Server) SetCoils(id byte, address uint16, values []bool) error {
+	return s.Update(func(m Memory) error {
+		return m.SetCoils(id, address, values)
+	})
+}
+
+// DiscreteInputs returns quantity discrete inputs of device id starting at address.
+func (s *Server) DiscreteInputs(id byte, address uint16, quantity int) (values []bool, err error) {
+	err = s.Update(func(m Memory) error {
+		values, err = m.DiscreteInputs(id, address, quantity)
+		return err
+	})
+	return values, err
+}
+
+// SetDiscreteInputs sets the discrete inputs of device id starting at address.
+func (s *Server) SetDiscreteInputs(id byte, address uint16, values []bool) error {
+	return s.Update(func(m Memory) error {
+		return m.SetDiscreteInputs(id, address, values)
+	})
+}
+
+// HoldingRegisters returns quantity holding registers of device id starting at address.
+func (s *Server) HoldingRegisters(id byte, address uint16, quantity int) (values []uint16, err error) {
+	err = s.Update(func(m Memory) error {
+		values, err = m.HoldingRegisters(id, address, quantity)
+		return err
+	})
+	return values, err
+}
+
+// SetHoldingRegisters sets the holding registers of device id starting at address.
+func (s *Server) SetHoldingRegisters(id byte, address uint16, values []uint16) error {
+	return s.Update(func(m Memory) error {
+		return m.SetHoldingRegisters(id, address, values)
+	})
+}
+
+// InputRegisters returns quantity input registers of device id starting at address.
+func (s *Server) InputRegisters(id byte, address uint16, quantity int) (values []uint16, err error) {
+	err = s.Update(func(m Memory) error {
+		values, err = m.InputRegisters(id, address, quantity)
+		return err
+	})
+	return values, err
+}
+
+// SetInputRegisters sets the input registers of device id starting at address.
+func (s *Server) SetInputRegisters(id byte, address uint16, values []uint16) error {
+	return s.Update(func(m Memory) error {
+		return m.SetInputRegisters(id, address, values)
+	})
+}
@@ -0,0 +1,111 @@
+package mbserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestMemory(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if err := s.SetCoils(1, 10, []bool{true, false, true}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	coils, err := s.Coils(1, 9, 5)
+	if expect := []bool{false, true, false, true, false}; err != nil || !isEqual(expect, coils) {
+		t.Errorf("expected %v, nil, got %v, %v", expect, coils, err)
+	}
+
+	if err := s.SetDiscreteInputs(1, 65535, []bool{true}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	inputs, err := s.DiscreteInputs(1, 65534, 2)
+	if expect := []bool{false, true}; err != nil || !isEqual(expect, inputs) {
+		t.Errorf("expected %v, nil, got %v, %v", expect, inputs, err)
+	}
+
+	if err := s.SetHoldingRegisters(1, 100, []uint16{1, 2}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	registers, err := s.HoldingRegisters(1, 100, 3)
+	if expect := []uint16{1, 2, 0}; err != nil || !isEqual(expect, registers) {
+		t.Errorf("expected %v, nil, got %v, %v", expect, registers, err)
+	}
+
+	if err := s.SetInputRegisters(1, 0, []uint16{0xffff}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	registers, err = s.InputRegisters(1, 0, 1)
+	if expect := []uint16{0xffff}; err != nil || !isEqual(expect, registers) {
+		t.Errorf("expected %v, nil, got %v, %v", expect, registers, err)
+	}
+}
+
+func TestMemoryErrors(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if _, err := s.HoldingRegisters(2, 0, 1); err == nil {
+		t.Errorf("unknown device: expected error not nil, got %v", err)
+	}
+	if err := s.SetHoldingRegisters(1, 65535, []uint16{1, 2}); err == nil {
+		t.Errorf("out of range: expected error not nil, got %v", err)
+	}
+	if _, err := s.Coils(1, 0, 65537); err == nil {
+		t.Errorf("out of range: expected error not nil, got %v", err)
+	}
+}
+
+func TestUpdateAtomic(t *testing.T) {
+	// Server
+	s := NewServer()
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	// The application updates the two registers of a 32-bit value in separate steps.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := uint16(0); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Update(func(m Memory) error {
+				if err := m.SetHoldingRegisters(1, 0, []uint16{i}); err != nil {
+					return err
+				}
+				return m.SetHoldingRegisters(1, 1, []uint16{i})
+			})
+			time.Sleep(10 * time.Microsecond)
+		}
+	}()
+
+	// Client
+	handler := modbus.NewTCPClientHandler(addr)
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	handler.SlaveId = 1
+	client := modbus.NewClient(handler)
+
+	for i := 0; i < 100; i++ {
+		results, err := client.ReadHoldingRegisters(0, 2)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if results[0] != results[2] || results[1] != results[3] {
+			t.Fatalf("expected equal registers, got %v", results)
+		}
+	}
+}
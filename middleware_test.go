@@ -0,0 +1,85 @@
+package mbserver
+
+import "testing"
+
+func TestUse(t *testing.T) {
+	s := NewServer()
+	s.SetHoldingRegisters(1, 1000, []uint16{42})
+	s.SetHoldingRegisters(1, 2000, []uint16{43})
+
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next FunctionHandler) FunctionHandler {
+			return func(s *Server, frame Framer) ([]byte, Exception) {
+				calls = append(calls, name)
+				return next(s, frame)
+			}
+		}
+	}
+	// Deny all writes.
+	readOnly := func(next FunctionHandler) FunctionHandler {
+		return func(s *Server, frame Framer) ([]byte, Exception) {
+			switch frame.GetFunction() {
+			case 5, 6, 15, 16:
+				return []byte{}, IllegalFunction
+			}
+			return next(s, frame)
+		}
+	}
+	// Translate the register addresses by 1000.
+	translate := func(next FunctionHandler) FunctionHandler {
+		return func(s *Server, frame Framer) ([]byte, Exception) {
+			register, number, _ := registerAddressAndNumber(frame)
+			translated := frame.Copy()
+			SetDataWithRegisterAndNumber(translated, uint16(register+1000), uint16(number))
+			return next(s, translated)
+		}
+	}
+	// Translate the register addresses of RTU requests by another 1000.
+	translateRTU := func(next RequestHandler) RequestHandler {
+		return func(s *Server, request *Request) ([]byte, Exception) {
+			calls = append(calls, "rtu")
+			if request.Transport != TransportRTU {
+				return next(s, request)
+			}
+			register, number, _ := registerAddressAndNumber(request.Frame())
+			translated := request.Frame().Copy()
+			SetDataWithRegisterAndNumber(translated, uint16(register+1000), uint16(number))
+			return next(s, request.WithFrame(translated))
+		}
+	}
+	s.Use(trace("first"), trace("second"))
+	s.Use(readOnly, translate)
+	s.UseRequest(translateRTU)
+
+	for _, test := range []struct {
+		function  byte
+		transport Transport
+		expect    Exception
+		data      []byte
+	}{
+		{3, TransportTCP, Success, []byte{2, 0, 42}},
+		{3, TransportRTU, Success, []byte{2, 0, 43}},
+		{6, TransportTCP, IllegalFunction, []byte{}},
+		{100, TransportTCP, IllegalFunction, []byte{}},
+	} {
+		calls = nil
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function}
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+		response := s.handle(&Request{frame: frame, Transport: test.transport})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("function %v: expected %v, got %v", test.function, test.expect, got)
+		}
+		if got := response.GetData(); test.expect == Success && !isEqual(test.data, got) {
+			t.Errorf("function %v: expected %v, got %v", test.function, test.data, got)
+		}
+		expect := []string{"first", "second", "rtu"}
+		if test.function == 6 {
+			// readOnly answers without calling the next handlers.
+			expect = expect[:2]
+		}
+		if !isEqual(expect, calls) {
+			t.Errorf("function %v: expected %v, got %v", test.function, expect, calls)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package mbserver
+
+// WriteEvent describes a successful write of a master to the memory of a device.
+// The values of coils are 0 or 1. Old is nil if the previous values could not be read from the Store.
+type WriteEvent struct {
+	Device   byte
+	Table    Table
+	Address  uint16
+	Quantity int
+	Old      []uint16
+	New      []uint16
+}
+
+// OnWrite registers fn to be called after each successful write of a master with
+// WriteSingleCoil, WriteHoldingRegister, WriteMultipleCoils or WriteHoldingRegisters.
+// fn is called by the handler goroutine after the response was sent and without
+// access to the device memory held, so it may use the accessors like HoldingRegisters.
+// No further request is handled until fn returns.
+func (s *Server) OnWrite(fn func(event WriteEvent)) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	s.onWrite = append(s.onWrite, fn)
+}
+
+// Subscribe returns a channel receiving the writes of the masters, see OnWrite.
+// The events are dropped if the channel buffer of size events is full.
+// Calling the returned function ends the subscription and closes the channel.
+func (s *Server) Subscribe(size int) (<-chan WriteEvent, func()) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	events := make(chan WriteEvent, size)
+	if s.subscribers == nil {
+		s.subscribers = map[chan WriteEvent]struct{}{}
+	}
+	s.subscribers[events] = struct{}{}
+
+	unsubscribe := func() {
+		s.subMu.Lock()
+		defer s.subMu.Unlock()
+		if _, ok := s.subscribers[events]; ok {
+			delete(s.subscribers, events)
+			close(events)
+		}
+	}
+	return events, unsubscribe
+}
+
+// watched returns true if there is a callback or a subscriber for write events.
+func (s *Server) watched() bool {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	return len(s.onWrite) > 0 || len(s.subscribers) > 0
+}
+
+// notify passes the events to the callbacks and subscribers.
+func (s *Server) notify(events []WriteEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.subMu.Lock()
+	callbacks := s.onWrite
+	for _, event := range events {
+		for subscriber := range s.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+				warninglog.Printf("write event of Device %v dropped, subscriber is full\n", event.Device)
+			}
+		}
+	}
+	s.subMu.Unlock()
+
+	for _, event := range events {
+		for _, fn := range callbacks {
+			fn(event)
+		}
+	}
+}
+
+// writeBits validates and writes the coils or discrete inputs of a device and records the write event.
+// deviceMu must be held.
+func (s *Server) writeBits(id byte, table Table, address uint16, values []bool) Exception {
+	device := s.Devices[id]
+	if !device.Mapped(table, address, len(values)) {
+		return IllegalDataAddress
+	}
+	if exception := device.validate(table, address, bitsToUint16(values)); exception != Success {
+		return exception
+	}
+
+	var old []bool
+	watched := s.watched()
+	if watched {
+		old, _ = device.ReadBits(table, address, len(values))
+	}
+	if exception := device.WriteBits(table, address, values); exception != Success {
+		return exception
+	}
+	if watched {
+		s.writeEvents = append(s.writeEvents, WriteEvent{
+			Device:   id,
+			Table:    table,
+			Address:  address,
+			Quantity: len(values),
+			Old:      bitsToUint16(old),
+			New:      bitsToUint16(values),
+		})
+	}
+	return Success
+}
+
+// writeRegisters validates and writes the holding or input registers of a device and records the write event.
+// deviceMu must be held.
+func (s *Server) writeRegisters(id byte, table Table, address uint16, values []uint16) Exception {
+	device := s.Devices[id]
+	if !device.Mapped(table, address, len(values)) {
+		return IllegalDataAddress
+	}
+	if exception := device.validate(table, address, values); exception != Success {
+		return exception
+	}
+
+	var old []uint16
+	watched := s.watched()
+	if watched {
+		old, _ = device.ReadRegisters(table, address, len(values))
+	}
+	if exception := device.WriteRegisters(table, address, values); exception != Success {
+		return exception
+	}
+	if watched {
+		s.writeEvents = append(s.writeEvents, WriteEvent{
+			Device:   id,
+			Table:    table,
+			Address:  address,
+			Quantity: len(values),
+			Old:      old,
+			New:      append([]uint16{}, values...),
+		})
+	}
+	return Success
+}
+
+func bitsToUint16(bits []bool) []uint16 {
+	if bits == nil {
+		return nil
+	}
+	values := make([]uint16, len(bits))
+	for i, bit := range bits {
+		if bit {
+			values[i] = 1
+		}
+	}
+	return values
+}
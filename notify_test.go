@@ -0,0 +1,74 @@
+package mbserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestWriteNotifications(t *testing.T) {
+	s := NewServer()
+	s.SetHoldingRegisters(1, 10, []uint16{1, 2})
+
+	callbacks := make(chan WriteEvent, 10)
+	s.OnWrite(func(event WriteEvent) {
+		// The device memory must be accessible from the callback.
+		if _, err := s.HoldingRegisters(1, 10, 1); err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+		callbacks <- event
+	})
+	events, unsubscribe := s.Subscribe(10)
+
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	time.Sleep(1 * time.Millisecond)
+
+	handler := modbus.NewTCPClientHandler(addr)
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	handler.SlaveId = 1
+	client := modbus.NewClient(handler)
+
+	client.WriteMultipleRegisters(10, 2, []byte{0, 3, 0, 4})
+	client.WriteSingleCoil(5, 0xff00)
+	// Failed writes are not notified.
+	client.WriteMultipleCoils(65535, 2, []byte{3})
+	// Reads are not notified.
+	client.ReadHoldingRegisters(10, 2)
+
+	expect := []WriteEvent{
+		{Device: 1, Table: HoldingRegisterTable, Address: 10, Quantity: 2, Old: []uint16{1, 2}, New: []uint16{3, 4}},
+		{Device: 1, Table: CoilTable, Address: 5, Quantity: 1, Old: []uint16{0}, New: []uint16{1}},
+	}
+	for _, e := range expect {
+		for _, c := range []<-chan WriteEvent{events, callbacks} {
+			select {
+			case got := <-c:
+				if !isEqual(e, got) {
+					t.Errorf("expected %v, got %v", e, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected %v, got timeout", e)
+			}
+		}
+	}
+
+	select {
+	case got := <-events:
+		t.Errorf("expected no further event, got %v", got)
+	default:
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Errorf("expected closed channel")
+	}
+	unsubscribe()
+}
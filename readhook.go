@@ -0,0 +1,81 @@
+package mbserver
+
+import "fmt"
+
+// ReadHook computes values when a master reads them. It is called with the part
+// of the requested range that overlaps the range of the hook and stores the values
+// with m, e.g. m.SetInputRegisters(id, address, values), before they are read.
+// An Exception other than Success, e.g. SlaveDeviceBusy, is returned to the master.
+// The hook must not call Update or the accessors of the Server, that deadlocks.
+type ReadHook func(m Memory, id byte, table Table, address uint16, quantity int) Exception
+
+// readHook is a ReadHook registered for a range of a table.
+type readHook struct {
+	table Table
+	addressRange
+	fn ReadHook
+}
+
+// OnRead registers hook for quantity addresses of table starting at address of device id.
+// The hook is called by the function handlers reading the table, e.g. ReadInputRegisters,
+// with exclusive access to the device memory.
+// For example:  s.OnRead(1, InputRegisterTable, 0, 2, sampleSensor)
+func (s *Server) OnRead(id byte, table Table, address uint16, quantity int, hook ReadHook) error {
+	return s.withDevice(id, func(device *Device) error {
+		if table > InputRegisterTable {
+			return fmt.Errorf("mbserver: invalid table %v", table)
+		}
+		if err := checkRange(address, quantity); err != nil {
+			return err
+		}
+		device.readHooks = append(device.readHooks, readHook{table, addressRange{int(address), int(address) + quantity}, hook})
+		return nil
+	})
+}
+
+// runReadHooks calls the hooks overlapping the range. deviceMu must be held.
+func (s *Server) runReadHooks(id byte, table Table, address uint16, quantity int) Exception {
+	start, end := int(address), int(address)+quantity
+	for _, hook := range s.Devices[id].readHooks {
+		if hook.table != table || hook.end <= start || end <= hook.start {
+			continue
+		}
+		from, to := hook.start, hook.end
+		if start > from {
+			from = start
+		}
+		if end < to {
+			to = end
+		}
+		if exception := hook.fn(Memory{s}, id, table, uint16(from), to-from); exception != Success {
+			return exception
+		}
+	}
+	return Success
+}
+
+// readBits reads the coils or discrete inputs of a device after calling the read hooks.
+// deviceMu must be held.
+func (s *Server) readBits(id byte, table Table, address uint16, quantity int) ([]bool, Exception) {
+	device := s.Devices[id]
+	if !device.Mapped(table, address, quantity) {
+		return nil, IllegalDataAddress
+	}
+	if exception := s.runReadHooks(id, table, address, quantity); exception != Success {
+		return nil, exception
+	}
+	return device.ReadBits(table, address, quantity)
+}
+
+// readRegisters reads the holding or input registers of a device after calling the read hooks.
+// deviceMu must be held.
+func (s *Server) readRegisters(id byte, table Table, address uint16, quantity int) ([]uint16, Exception) {
+	device := s.Devices[id]
+	if !device.Mapped(table, address, quantity) {
+		return nil, IllegalDataAddress
+	}
+	if exception := s.runReadHooks(id, table, address, quantity); exception != Success {
+		return nil, exception
+	}
+	return device.ReadRegisters(table, address, quantity)
+}
@@ -0,0 +1,59 @@
+package mbserver
+
+import "testing"
+
+func TestOnRead(t *testing.T) {
+	s := NewServer()
+	s.SetInputRegisters(1, 0, []uint16{7, 7, 7, 7})
+
+	var calls [][]int
+	counter := uint16(0)
+	s.OnRead(1, InputRegisterTable, 1, 2, func(m Memory, id byte, table Table, address uint16, quantity int) Exception {
+		calls = append(calls, []int{int(address), quantity})
+		counter++
+		values := make([]uint16, quantity)
+		for i := range values {
+			values[i] = counter
+		}
+		if err := m.SetInputRegisters(id, address, values); err != nil {
+			return SlaveDeviceFailure
+		}
+		return Success
+	})
+	s.OnRead(1, CoilTable, 0, 1, func(m Memory, id byte, table Table, address uint16, quantity int) Exception {
+		return SlaveDeviceBusy
+	})
+
+	for _, test := range []struct {
+		function byte
+		address  uint16
+		number   uint16
+		expect   Exception
+		data     []byte
+	}{
+		{4, 0, 4, Success, []byte{8, 0, 7, 0, 1, 0, 1, 0, 7}},
+		{4, 2, 2, Success, []byte{4, 0, 2, 0, 7}},
+		{4, 3, 1, Success, []byte{2, 0, 7}},
+		{3, 1, 1, Success, []byte{2, 0, 0}},
+		{1, 0, 2, SlaveDeviceBusy, []byte{}},
+		{1, 1, 2, Success, []byte{1, 0}},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function}
+		SetDataWithRegisterAndNumber(frame, test.address, test.number)
+		response := s.handle(&Request{frame: frame})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("function %v address %v: expected %v, got %v", test.function, test.address, test.expect, got)
+		}
+		if got := response.GetData(); test.expect == Success && !isEqual(test.data, got) {
+			t.Errorf("function %v address %v: expected %v, got %v", test.function, test.address, test.data, got)
+		}
+	}
+
+	expect := [][]int{{1, 2}, {2, 1}}
+	if !isEqual(expect, calls) {
+		t.Errorf("expected %v, got %v", expect, calls)
+	}
+	if err := s.OnRead(2, CoilTable, 0, 1, nil); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
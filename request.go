@@ -0,0 +1,181 @@
+package mbserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transport is the kind of connection a request was received by.
+type Transport uint8
+
+const (
+	// TransportTCP is Modbus TCP, see ListenTCP.
+	TransportTCP Transport = iota
+	// TransportRTUOverTCP is Modbus RTU tunneled over TCP, see ListenRTUOverTCP.
+	TransportRTUOverTCP
+	// TransportUDP is Modbus UDP, see ListenUDP.
+	TransportUDP
+	// TransportTLS is Modbus/TCP Security, see ListenTLS.
+	TransportTLS
+	// TransportRTU is Modbus RTU on a serial device, see ListenRTU.
+	TransportRTU
+	// TransportASCII is Modbus ASCII on a serial device, see ListenASCII.
+	TransportASCII
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportTCP:
+		return "TCP"
+	case TransportRTUOverTCP:
+		return "RTUOverTCP"
+	case TransportUDP:
+		return "UDP"
+	case TransportTLS:
+		return "TLS"
+	case TransportRTU:
+		return "RTU"
+	case TransportASCII:
+		return "ASCII"
+	default:
+		return "unknown"
+	}
+}
+
+// Serial reports whether the transport is a serial line.
+func (t Transport) Serial() bool {
+	return t == TransportRTU || t == TransportASCII
+}
+
+// Request is a request received from a master and the context it was received in.
+type Request struct {
+	// reply writes the response to the connection, serial port or address the request was received from.
+	reply io.Writer
+	frame Framer
+	ctx   context.Context
+	// diag is the diagnostic state of the serial line, nil for TCP/IP.
+	diag *serialDiagnostics
+
+	// Transport is the kind of connection the request was received by.
+	Transport Transport
+	// RemoteAddr is the address of the master, nil for serial devices.
+	RemoteAddr net.Addr
+	// Listener is the local address the request was received on.
+	// For serial devices it is a SerialAddr.
+	Listener net.Addr
+	// Received is the time the request was received.
+	Received time.Time
+	// Session holds the state of the connection or serial device across requests.
+	// Every datagram of Modbus UDP has a new session.
+	Session *Session
+	// Role is the Modbus role of the client certificate, empty if the request wasn't
+	// received by ListenTLS or the certificate has no role.
+	Role string
+}
+
+// Frame returns the frame of the request.
+func (r *Request) Frame() Framer {
+	return r.frame
+}
+
+// Context returns the context of the request. It is canceled when the connection
+// is closed or the server is shut down.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithFrame returns a shallow copy of the request with the frame replaced, e.g. for
+// a middleware translating addresses.
+func (r *Request) WithFrame(frame Framer) *Request {
+	request := *r
+	request.frame = frame
+	return &request
+}
+
+// Session holds state of a connection across requests, e.g. the result of a login
+// implemented by a function handler. It is safe for concurrent use.
+type Session struct {
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+}
+
+// Value returns the value stored for key, nil if there is none.
+func (s *Session) Value(key interface{}) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// SetValue stores value for key.
+func (s *Session) SetValue(key, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = map[interface{}]interface{}{}
+	}
+	s.values[key] = value
+}
+
+// SerialAddr is the Listener address of requests received from a serial device.
+type SerialAddr struct {
+	Transport Transport
+	// Name is the name of the serial device if it has a Name method like *os.File.
+	Name string
+}
+
+// Network returns "rtu" or "ascii".
+func (a SerialAddr) Network() string {
+	if a.Transport == TransportASCII {
+		return "ascii"
+	}
+	return "rtu"
+}
+
+func (a SerialAddr) String() string {
+	if a.Name == "" {
+		return "serial"
+	}
+	return a.Name
+}
+
+// newSerialAddr returns the address of a serial device.
+func newSerialAddr(port io.ReadWriteCloser, transport Transport) SerialAddr {
+	addr := SerialAddr{Transport: transport}
+	if named, ok := port.(interface{ Name() string }); ok {
+		addr.Name = named.Name()
+	}
+	return addr
+}
+
+// source creates the requests received from a connection, serial device or remote address.
+type source struct {
+	reply     io.Writer
+	ctx       context.Context
+	transport Transport
+	remote    net.Addr
+	local     net.Addr
+	session   *Session
+	diag      *serialDiagnostics
+	role      string
+}
+
+func (src *source) request(frame Framer) *Request {
+	return &Request{
+		reply:      src.reply,
+		frame:      frame,
+		ctx:        src.ctx,
+		Transport:  src.transport,
+		RemoteAddr: src.remote,
+		Listener:   src.local,
+		Received:   time.Now(),
+		Session:    src.session,
+		diag:       src.diag,
+		Role:       src.role,
+	}
+}
@@ -0,0 +1,134 @@
+package mbserver
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRequestContext(t *testing.T) {
+	s := NewServer()
+	requests := make(chan *Request, 10)
+	// Function 65 counts the requests of the session.
+	s.RegisterHandler(65, func(s *Server, request *Request) ([]byte, Exception) {
+		count, _ := request.Session.Value("count").(int)
+		request.Session.SetValue("count", count+1)
+		requests <- request
+		return []byte{byte(count + 1)}, Success
+	})
+
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	start := time.Now()
+	var request *Request
+	for i := byte(1); i <= 2; i++ {
+		frame := &TCPFrame{TransactionIdentifier: uint16(i), Device: 1, Function: 65}
+		SetDataWithRegisterAndNumber(frame, 0, 0)
+		conn.Write(frame.Bytes())
+		response, err := ReadTCPFrame(conn)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if got := response.GetData(); !isEqual([]byte{i}, got) {
+			t.Errorf("expected %v, got %v", []byte{i}, got)
+		}
+		request = <-requests
+	}
+
+	if request.Transport != TransportTCP {
+		t.Errorf("expected %v, got %v", TransportTCP, request.Transport)
+	}
+	if got := request.RemoteAddr.String(); got != conn.LocalAddr().String() {
+		t.Errorf("expected %v, got %v", conn.LocalAddr(), got)
+	}
+	if got := request.Listener.String(); got != addr {
+		t.Errorf("expected %v, got %v", addr, got)
+	}
+	if request.Received.Before(start) || request.Received.After(time.Now()) {
+		t.Errorf("expected receive time after %v, got %v", start, request.Received)
+	}
+	if err := request.Context().Err(); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	// The context is canceled when the connection is closed.
+	conn.Close()
+	select {
+	case <-request.Context().Done():
+	case <-time.After(time.Second):
+		t.Errorf("expected canceled context")
+	}
+}
+
+func TestRequestContextUDP(t *testing.T) {
+	s := NewServer()
+	requests := make(chan *Request, 10)
+	s.UseRequest(func(next RequestHandler) RequestHandler {
+		return func(s *Server, request *Request) ([]byte, Exception) {
+			requests <- request
+			return next(s, request)
+		}
+	})
+
+	addr := getFreePort()
+	if err := s.ListenUDP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 1, 1)
+	conn.Write(frame.Bytes())
+
+	var request *Request
+	select {
+	case request = <-requests:
+	case <-time.After(time.Second):
+		t.Fatalf("expected request, got timeout")
+	}
+	if request.Transport != TransportUDP || request.Transport.Serial() {
+		t.Errorf("expected %v, got %v", TransportUDP, request.Transport)
+	}
+	if got := request.RemoteAddr.String(); got != conn.LocalAddr().String() {
+		t.Errorf("expected %v, got %v", conn.LocalAddr(), got)
+	}
+	if request.Session == nil {
+		t.Errorf("expected session")
+	}
+
+	// The context is canceled when the server is shut down.
+	s.Close()
+	select {
+	case <-request.Context().Done():
+	case <-time.After(time.Second):
+		t.Errorf("expected canceled context")
+	}
+}
+
+func TestSerialAddr(t *testing.T) {
+	addr := SerialAddr{Transport: TransportASCII}
+	if addr.Network() != "ascii" || addr.String() != "serial" {
+		t.Errorf("expected ascii serial, got %v %v", addr.Network(), addr)
+	}
+	addr = SerialAddr{Transport: TransportRTU, Name: "/dev/ttyUSB0"}
+	if addr.Network() != "rtu" || addr.String() != "/dev/ttyUSB0" {
+		t.Errorf("expected rtu /dev/ttyUSB0, got %v %v", addr.Network(), addr)
+	}
+}
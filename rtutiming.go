@@ -0,0 +1,146 @@
+package mbserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// rtuTiming contains the silent intervals used to delimit Modbus RTU frames.
+type rtuTiming struct {
+	// char is the transmission time of one character.
+	char time.Duration
+	// t15 is the maximum silent interval between two characters of a frame.
+	t15 time.Duration
+	// t35 is the minimum silent interval between two frames.
+	t35 time.Duration
+}
+
+// newRTUTiming calculates the RTU silent intervals for a baud rate.
+// Above 19200 baud the fixed values of the Modbus serial line specification
+// (750µs and 1750µs) are used.
+func newRTUTiming(baudRate int) (rtuTiming, error) {
+	if baudRate <= 0 {
+		return rtuTiming{}, fmt.Errorf("mbserver: invalid baud rate %v", baudRate)
+	}
+
+	// An RTU character has 11 bits: start bit, 8 data bits, parity bit (or a second stop bit) and stop bit.
+	char := 11 * time.Second / time.Duration(baudRate)
+	timing := rtuTiming{
+		char: char,
+		t15:  char * 3 / 2,
+		t35:  char * 7 / 2,
+	}
+	if baudRate > 19200 {
+		timing.t15 = 750 * time.Microsecond
+		timing.t35 = 1750 * time.Microsecond
+	}
+	return timing, nil
+}
+
+// rtuReader delimits RTU frames by the silent intervals between the received characters.
+type rtuReader struct {
+	timing  rtuTiming
+	chunks  <-chan serialChunk
+	pending *serialChunk
+	// diag counts the overruns, it may be nil.
+	diag *serialDiagnostics
+}
+
+// newRTUReader delimits the frames received from chunks.
+func newRTUReader(chunks <-chan serialChunk, timing rtuTiming) *rtuReader {
+	return &rtuReader{
+		timing: timing,
+		chunks: chunks,
+	}
+}
+
+// next returns a chunk that was received after the end of the previous frame
+// or waits for new data. It returns false if the channel is closed.
+func (r *rtuReader) next() (serialChunk, bool) {
+	if r.pending != nil {
+		chunk := *r.pending
+		r.pending = nil
+		return chunk, true
+	}
+	chunk, ok := <-r.chunks
+	return chunk, ok
+}
+
+// receive returns the next chunk. A queued chunk is returned without waiting, so
+// the end of a frame is decided from the receive times of the chunks even if the
+// handler was busy. If no chunk is queued, it waits until deadline and returns
+// timeout true.
+func (r *rtuReader) receive(deadline time.Time) (chunk serialChunk, ok bool, timeout bool) {
+	select {
+	case chunk, ok = <-r.chunks:
+		return chunk, ok, false
+	default:
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case chunk, ok = <-r.chunks:
+		return chunk, ok, false
+	case <-timer.C:
+	}
+
+	// A chunk may have been queued while the timer expired.
+	select {
+	case chunk, ok = <-r.chunks:
+		return chunk, ok, false
+	default:
+		return serialChunk{}, true, true
+	}
+}
+
+// ReadFrame returns the next frame. A frame ends with a silent interval of at
+// least t3.5. Frames containing a silent interval of more than t1.5 are
+// incomplete and are discarded.
+func (r *rtuReader) ReadFrame() ([]byte, error) {
+	for {
+		chunk, ok := r.next()
+		if !ok {
+			return nil, io.EOF
+		}
+		frame := chunk.data
+		last := chunk.received
+		complete := true
+
+		for {
+			chunk, ok, timeout := r.receive(last.Add(r.timing.t35))
+			if timeout {
+				break
+			}
+			if !ok {
+				return nil, io.EOF
+			}
+
+			// The chunk was received after its last character, so the
+			// transmission time of its characters is not silence.
+			silence := chunk.received.Sub(last) - time.Duration(len(chunk.data))*r.timing.char
+			if silence >= r.timing.t35 {
+				r.pending = &chunk
+				break
+			}
+			if silence > r.timing.t15 {
+				complete = false
+			}
+			frame = append(frame, chunk.data...)
+			last = chunk.received
+		}
+
+		if !complete {
+			warninglog.Printf("incomplete serial frame, silent interval exceeds t1.5: %v", hex.EncodeToString(frame))
+			continue
+		}
+		if len(frame) > rtuMaxSize {
+			warninglog.Printf("serial frame exceeds %v bytes: %v", rtuMaxSize, hex.EncodeToString(frame))
+			r.diag.lineError(busCharacterOverrunCount)
+			continue
+		}
+		return frame, nil
+	}
+}
@@ -0,0 +1,92 @@
+package mbserver
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNewRTUTiming(t *testing.T) {
+	timing, err := newRTUTiming(9600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if expect := 1145833 * time.Nanosecond; timing.char != expect {
+		t.Errorf("expected %v, got %v", expect, timing.char)
+	}
+	if expect := timing.char * 7 / 2; timing.t35 != expect {
+		t.Errorf("expected %v, got %v", expect, timing.t35)
+	}
+
+	timing, err = newRTUTiming(115200)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if expect := 750 * time.Microsecond; timing.t15 != expect {
+		t.Errorf("expected %v, got %v", expect, timing.t15)
+	}
+	if expect := 1750 * time.Microsecond; timing.t35 != expect {
+		t.Errorf("expected %v, got %v", expect, timing.t35)
+	}
+
+	if _, err = newRTUTiming(0); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
+
+func TestRTUReader(t *testing.T) {
+	// 300 baud: character time 36.7ms, t1.5 55ms, t3.5 128.3ms
+	timing, _ := newRTUTiming(300)
+	pr, pw := io.Pipe()
+	s := NewServer()
+	defer s.Close()
+	defer pw.Close()
+	reader := newRTUReader(s.readSerial(pr), timing)
+
+	frame1 := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a}
+	frame2 := []byte{0x02, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x39}
+
+	go func() {
+		// A fragmented frame.
+		pw.Write(frame1[:3])
+		pw.Write(frame1[3:])
+		time.Sleep(300 * time.Millisecond)
+
+		// A frame with a silent interval > t1.5 is discarded.
+		pw.Write(frame2[:1])
+		time.Sleep(125 * time.Millisecond)
+		pw.Write(frame2[1:2])
+		time.Sleep(300 * time.Millisecond)
+
+		pw.Write(frame2)
+	}()
+
+	for _, expect := range [][]byte{frame1, frame2} {
+		if got, _ := reader.ReadFrame(); !isEqual(expect, got) {
+			t.Errorf("expected %v, got %v", expect, got)
+		}
+	}
+}
+
+func TestRTUReaderQueued(t *testing.T) {
+	// The chunks were received while the handler was busy, the frames are
+	// delimited by their receive times only.
+	timing, _ := newRTUTiming(19200)
+	frame1 := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a}
+	frame2 := []byte{0x02, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x39}
+
+	for i := 0; i < 100; i++ {
+		received := time.Now().Add(-time.Second)
+		chunks := make(chan serialChunk, 3)
+		chunks <- serialChunk{data: frame1[:3], received: received}
+		chunks <- serialChunk{data: frame1[3:], received: received.Add(5 * timing.char)}
+		chunks <- serialChunk{data: frame2, received: received.Add(100 * time.Millisecond)}
+		reader := newRTUReader(chunks, timing)
+
+		for _, expect := range [][]byte{frame1, frame2} {
+			if got, _ := reader.ReadFrame(); !isEqual(expect, got) {
+				t.Fatalf("expected %v, got %v", expect, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package mbserver
+
+import (
+	"io"
+	"time"
+)
+
+// asciiReader delimits ASCII frames by the start character ':' and the end delimiter.
+type asciiReader struct {
+	// timeout is the maximum interval between two characters of a frame, 0 disables the timeout.
+	timeout time.Duration
+	// diag provides the end delimiter of a frame, LF by default, and counts the overruns.
+	diag   *serialDiagnostics
+	chunks <-chan serialChunk
+	buffer []byte
+	frame  []byte
+	// last is the time the last characters were received.
+	last time.Time
+}
+
+// newASCIIReader delimits the frames received from chunks.
+func newASCIIReader(chunks <-chan serialChunk, timeout time.Duration) *asciiReader {
+	return &asciiReader{
+		timeout: timeout,
+		chunks:  chunks,
+	}
+}
+
+// ReadFrame returns the next frame from the colon up to and including the
+// delimiter, which is replaced by LF. A colon restarts the frame and a frame
+// exceeding the inter-character timeout is discarded.
+func (r *asciiReader) ReadFrame() ([]byte, error) {
+	for {
+		for len(r.buffer) > 0 {
+			c := r.buffer[0]
+			r.buffer = r.buffer[1:]
+
+			switch {
+			case c == ':':
+				if len(r.frame) > 0 {
+					warninglog.Printf("incomplete ascii frame, restarted by a colon: %q", r.frame)
+				}
+				r.frame = []byte{c}
+			case r.frame == nil:
+				// Characters outside of a frame are ignored.
+			case c == r.diag.asciiDelimiter():
+				frame := append(r.frame, '\n')
+				r.frame = nil
+				return frame, nil
+			case len(r.frame) >= asciiMaxSize:
+				warninglog.Printf("ascii frame exceeds %v characters: %q", asciiMaxSize, r.frame)
+				r.diag.lineError(busCharacterOverrunCount)
+				r.frame = nil
+			default:
+				r.frame = append(r.frame, c)
+			}
+		}
+
+		chunk, ok := <-r.chunks
+		if !ok {
+			return nil, io.EOF
+		}
+		if r.frame != nil && r.timeout > 0 && chunk.received.Sub(r.last) > r.timeout {
+			warninglog.Printf("incomplete ascii frame, inter-character timeout exceeded: %q", r.frame)
+			r.frame = nil
+		}
+		r.buffer = chunk.data
+		r.last = chunk.received
+	}
+}
+
+// ListenASCII starts the Modbus server listening to a serial device using the Modbus ASCII transmission mode.
+// timeout is the maximum interval between two characters of a frame,
+// the Modbus serial line specification suggests one second. A timeout of 0 disables the check.
+// For example:  err := s.ListenASCII(port, time.Second)
+func (s *Server) ListenASCII(port io.ReadWriteCloser, timeout time.Duration) (err error) {
+	diag := newSerialDiagnostics()
+	return s.start(port,
+		func() { s.ports = append(s.ports, port) },
+		func() {
+			reader := newASCIIReader(s.readSerial(port), timeout)
+			reader.diag = diag
+			s.acceptSerialRequests(port, TransportASCII, diag, reader, decodeASCIIFrame)
+		})
+}
+
+func decodeASCIIFrame(packet []byte) (Framer, error) {
+	return NewASCIIFrame(packet)
+}
@@ -2,35 +2,83 @@
 package mbserver
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 )
 
+// ErrServerClosed is returned by the Listen functions and Serve after the server was shut down.
+var ErrServerClosed = errors.New("mbserver: Server closed")
+
 // Server is a Modbus slave with allocated memory for discrete inputs, coils, etc.
 type Server struct {
 	// Debug enables more verbose messaging.
-	Debug       bool
+	Debug bool
+
+	// mu guards the listeners, packet connections, client connections and ports.
+	mu          sync.Mutex
 	listeners   []net.Listener
+	packetConns []net.PacketConn
+	conns       map[net.Conn]struct{}
 	ports       []io.ReadWriteCloser
+	// wg counts all goroutines of the server.
+	wg sync.WaitGroup
+	// done is closed when the server is shut down.
+	done chan struct{}
+	// ctx is the parent of the request contexts, canceled when the server is shut down.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// handlerDone is closed when the handler goroutine has exited.
+	handlerDone chan struct{}
 	requestChan chan *Request
-	function    [256]func(*Server, Framer) ([]byte, Exception)
-	Devices     map[byte]Device
-}
+	function    [256]RequestHandler
+	middleware  []RequestMiddleware
+
+	// deviceMu serializes the request handling with the access of the application
+	// to the devices, see Update.
+	deviceMu sync.Mutex
+	// Devices contains the memory of the Modbus devices. The handler goroutine accesses
+	// the devices while the server is running, use Update or the accessors like
+	// HoldingRegisters and SetHoldingRegisters to access them from the application.
+	Devices map[byte]*Device
+	// writeEvents are the writes of the current request, guarded by deviceMu.
+	writeEvents []WriteEvent
 
-// Request contains the connection and Modbus frame.
-type Request struct {
-	conn  io.ReadWriteCloser
-	frame Framer
+	// subMu guards the write callbacks and subscribers.
+	subMu       sync.Mutex
+	onWrite     []func(WriteEvent)
+	subscribers map[chan WriteEvent]struct{}
 }
 
 // Device contains the Registers of a Modbus Device.
+// The slices DiscreteInputs, Coils, HoldingRegisters and InputRegisters of earlier
+// versions are replaced by Store, an ArrayStore provides them for existing code.
 type Device struct {
-	DiscreteInputs   []byte
-	Coils            []byte
-	HoldingRegisters []uint16
-	InputRegisters   []uint16
+	// Store is the memory of the device, a MemoryStore by default.
+	Store Store
+	// addressMap contains the ranges declared with MapAddresses, nil if all addresses exist.
+	addressMap map[Table][]addressRange
+	// readHooks are the hooks registered with OnRead.
+	readHooks []readHook
+	// validators are the validators registered with Validate.
+	validators []validator
+	// function contains the handlers registered with RegisterDeviceHandler,
+	// the handlers of the server are used for the other function codes.
+	function map[uint8]RequestHandler
+	// disabled contains the function codes answered with IllegalFunction.
+	disabled [256]bool
+	// exceptionStatus is returned by ReadExceptionStatus.
+	exceptionStatus exceptionStatus
+	// diagnosticRegister is returned by Diagnostics.
+	diagnosticRegister uint16
+	// serverID is returned by ReportServerID, nil for the default identity.
+	serverID *ServerID
+	// identification contains the objects returned by ReadDeviceIdentification.
+	identification map[DeviceObject]string
 }
 
 // TODO Sollte auch nur New heißen
@@ -39,46 +87,64 @@ func NewServer() *Server {
 	s := &Server{}
 
 	// Add default functions.
-	s.function[1] = ReadCoils
-	s.function[2] = ReadDiscreteInputs
-	s.function[3] = ReadHoldingRegisters
-	s.function[4] = ReadInputRegisters
-	s.function[5] = WriteSingleCoil
-	s.function[6] = WriteHoldingRegister
-	s.function[15] = WriteMultipleCoils
-	s.function[16] = WriteHoldingRegisters
+	s.RegisterFunctionHandler(1, ReadCoils)
+	s.RegisterFunctionHandler(2, ReadDiscreteInputs)
+	s.RegisterFunctionHandler(3, ReadHoldingRegisters)
+	s.RegisterFunctionHandler(4, ReadInputRegisters)
+	s.RegisterFunctionHandler(5, WriteSingleCoil)
+	s.RegisterFunctionHandler(6, WriteHoldingRegister)
+	s.RegisterHandler(7, ReadExceptionStatus)
+	s.RegisterHandler(8, Diagnostics)
+	s.RegisterHandler(11, GetCommEventCounter)
+	s.RegisterHandler(12, GetCommEventLog)
+	s.RegisterHandler(17, ReportServerID)
+	s.RegisterHandler(43, ReadDeviceIdentification)
+	s.RegisterFunctionHandler(15, WriteMultipleCoils)
+	s.RegisterFunctionHandler(16, WriteHoldingRegisters)
 
 	// Allocate Modbus memory maps.
-	s.Devices = map[byte]Device{}
+	s.Devices = map[byte]*Device{}
 	_ = s.NewDevice(1)
 
+	s.conns = map[net.Conn]struct{}{}
+	s.done = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.handlerDone = make(chan struct{})
 	s.requestChan = make(chan *Request)
-	go s.handler()
+	s.spawn(s.handler)
 
 	return s
 }
 
 // TODO >> sollte einen Pointer zu den Registern zurückgeben
 // TODO Sollte auch nur New heißen
+// NewDevice adds a device with a MemoryStore for the full address space of all four tables.
 func (s *Server) NewDevice(id byte) error {
+	return s.NewDeviceWithStore(id, NewMemoryStore())
+}
+
+// NewDeviceWithStore adds a device using store as device memory.
+// It must not be called by a function handler, see Update.
+func (s *Server) NewDeviceWithStore(id byte, store Store) error {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
 	if id < idmin || id > idmax {
 		return fmt.Errorf("invalid modbus id %v", id)
 	}
 	if _, ok := s.Devices[id]; ok {
 		return fmt.Errorf("mbserver: device %v already exists", id)
 	}
-	s.Devices[id] = Device{
-		DiscreteInputs:   make([]byte, 65536),
-		Coils:            make([]byte, 65536),
-		HoldingRegisters: make([]uint16, 65536),
-		InputRegisters:   make([]uint16, 65536),
-	}
+	s.Devices[id] = &Device{Store: store}
 
 	return nil
 }
 
 // TODO Sollte auch nur Close heißen
 func (s *Server) RemoveDevice(id byte) error {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
 	if id < idmin && id > idmax {
 		return fmt.Errorf("invalid modbus id %v", id)
 	}
@@ -90,26 +156,116 @@ func (s *Server) RemoveDevice(id byte) error {
 	return nil
 }
 
+// device returns device id. deviceMu must be held.
+func (s *Server) device(id byte) (*Device, error) {
+	device, ok := s.Devices[id]
+	if !ok {
+		return nil, fmt.Errorf("mbserver: device %v doesn't exists", id)
+	}
+	return device, nil
+}
+
+// withDevice calls fn with device id while holding deviceMu.
+func (s *Server) withDevice(id byte, fn func(device *Device) error) error {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
+	device, err := s.device(id)
+	if err != nil {
+		return err
+	}
+	return fn(device)
+}
+
+// FunctionHandler handles the requests of a Modbus function and returns the data of the response.
+type FunctionHandler func(s *Server, frame Framer) ([]byte, Exception)
+
+// RequestHandler handles the requests of a Modbus function like a FunctionHandler,
+// with access to the context of the request, e.g. the transport and remote address.
+type RequestHandler func(s *Server, request *Request) ([]byte, Exception)
+
+// Adapt returns a RequestHandler calling function with the frame of the request.
+func Adapt(function FunctionHandler) RequestHandler {
+	return func(s *Server, request *Request) ([]byte, Exception) {
+		return function(s, withRole(request, request.frame))
+	}
+}
+
+// Middleware wraps the FunctionHandler of every request, e.g. to add logging,
+// metrics or access control. It may pass a changed copy of the frame to next, e.g.
+// to translate addresses, or answer the request itself without calling next.
+type Middleware func(next FunctionHandler) FunctionHandler
+
+// RequestMiddleware wraps the RequestHandler of every request like a Middleware,
+// with access to the context of the request. It may pass a copy of the request
+// with a changed frame to next, see Request.WithFrame.
+type RequestMiddleware func(next RequestHandler) RequestHandler
+
+// request converts m to a RequestMiddleware. The context of the request is kept
+// when m passes a changed frame to next.
+func (m Middleware) request() RequestMiddleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(s *Server, request *Request) ([]byte, Exception) {
+			current := withRole(request, request.frame)
+			function := m(func(s *Server, frame Framer) ([]byte, Exception) {
+				if frame != current {
+					return next(s, request.WithFrame(withoutRole(frame)))
+				}
+				return next(s, request)
+			})
+			return function(s, current)
+		}
+	}
+}
+
 // RegisterFunctionHandler override the default behavior for a given Modbus function.
-func (s *Server) RegisterFunctionHandler(funcCode uint8, function func(*Server, Framer) ([]byte, Exception)) {
-	s.function[funcCode] = function
+// The handler must not call Update or the accessors of the Server, see RegisterHandler.
+func (s *Server) RegisterFunctionHandler(funcCode uint8, function FunctionHandler) {
+	s.function[funcCode] = Adapt(function)
 }
 
-func (s *Server) handle(request *Request) Framer {
-	var exception Exception
-	var data []byte
+// RegisterHandler override the default behavior for a given Modbus function with a handler
+// using the context of the request.
+// The handler runs while the device memory is held by the request handling and accesses
+// s.Devices directly. Calling Update, the accessors like HoldingRegisters or the device
+// configuration methods like NewDevice from the handler deadlocks the server.
+func (s *Server) RegisterHandler(funcCode uint8, handler RequestHandler) {
+	s.function[funcCode] = handler
+}
+
+// Use appends middleware to the chain around the function handlers. The middleware
+// added first is called first. Requests of unsupported functions pass the chain too,
+// the innermost handler answers them with IllegalFunction.
+// Use must be called before the server starts listening. Like function handlers,
+// middleware must not call Update or the accessors of the Server, see RegisterHandler.
+func (s *Server) Use(middleware ...Middleware) {
+	for _, m := range middleware {
+		s.middleware = append(s.middleware, m.request())
+	}
+}
 
+// UseRequest appends middleware using the context of the request to the chain, see Use.
+// The middleware added by Use and UseRequest is called in the order it was added.
+func (s *Server) UseRequest(middleware ...RequestMiddleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// illegalFunction answers the requests of unsupported functions.
+func illegalFunction(s *Server, request *Request) ([]byte, Exception) {
+	infolog.Printf("IllegalFunction: %v\n", request.frame.GetFunction())
+	return []byte{}, IllegalFunction
+}
+
+func (s *Server) handle(request *Request) Framer {
 	response := request.frame.Copy()
-	function := request.frame.GetFunction()
 
-	if s.function[function] != nil {
-		data, exception = s.function[function](s, request.frame)
-		response.SetData(data)
-	} else {
-		infolog.Printf("IllegalFunction: %v\n", function)
-		exception = IllegalFunction
+	function := s.lookup(request.frame.GetDevice(), request.frame.GetFunction())
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		function = s.middleware[i](function)
 	}
 
+	data, exception := s.call(function, request)
+	response.SetData(data)
 	if exception != Success {
 		response.SetException(exception)
 	}
@@ -117,39 +273,228 @@ func (s *Server) handle(request *Request) Framer {
 	return response
 }
 
+// call calls the handler of a request. A panic of the handler, e.g. caused by a
+// malformed request, is answered with SlaveDeviceFailure, so the server keeps serving.
+func (s *Server) call(function RequestHandler, request *Request) (data []byte, exception Exception) {
+	defer func() {
+		if r := recover(); r != nil {
+			errorlog.Printf("function %v of Device %v panicked: %v\n", request.frame.GetFunction(), request.frame.GetDevice(), r)
+			data, exception = []byte{}, SlaveDeviceFailure
+		}
+	}()
+	return function(s, request)
+}
+
 // All requests are handled synchronously to prevent modbus memory corruption.
 func (s *Server) handler() {
+	defer close(s.handlerDone)
+
 	for {
-		request := <-s.requestChan
-		device := request.frame.GetDevice()
-		if device == 0 {
-			debuglog.Printf("start modbus broadcast")
-			for device, _ := range s.Devices {
-				request.frame.SetDevice(device)
-				_ = s.handle(request)
-				//  Broadcast doesn't send response!!
-			}
-			debuglog.Printf("end modbus broadcast:")
-		} else {
-			if _, ok := s.Devices[device]; !ok {
-				//  ignore request if device is unknown
-				debuglog.Printf("unknown deviceid: %v\n", device)
-				continue
-			}
-			response := s.handle(request)
+		var request *Request
+		select {
+		case request = <-s.requestChan:
+		case <-s.done:
+			return
+		}
+
+		s.deviceMu.Lock()
+		response := s.process(request)
+		events := s.writeEvents
+		s.writeEvents = nil
+		s.deviceMu.Unlock()
+
+		if response != nil {
 			r := response.Bytes()
-			tracelog.Printf("write serial port: %v", hex.EncodeToString(r))
-			request.conn.Write(r)
+			tracelog.Printf("write response: %v", hex.EncodeToString(r))
+			request.reply.Write(r)
 		}
+		s.notify(events)
 	}
 }
 
-// Close stops listening to TCP/IP ports and closes serial ports.
-func (s *Server) Close() {
+// process handles a request and returns the response,
+// nil if no response is sent. deviceMu must be held.
+func (s *Server) process(request *Request) Framer {
+	diag := request.diag
+	device := request.frame.GetDevice()
+	if _, ok := s.Devices[device]; !ok && device != 0 {
+		//  ignore request if device is unknown
+		debuglog.Printf("unknown deviceid: %v\n", device)
+		return nil
+	}
+	diag.received(device == 0)
+
+	// In listen only mode the serial line only handles Restart Communications Option.
+	listenOnly := diag.isListenOnly()
+	if listenOnly && !isRestart(request.frame) {
+		diag.count(serverNoResponseCount)
+		return nil
+	}
+
+	var response Framer
+	var exception Exception
+	if device == 0 {
+		debuglog.Printf("start modbus broadcast")
+		for device, _ := range s.Devices {
+			request.frame.SetDevice(device)
+			if e := GetException(s.handle(request)); e != Success {
+				exception = e
+			}
+			//  Broadcast doesn't send response!!
+		}
+		debuglog.Printf("end modbus broadcast:")
+	} else {
+		response = s.handle(request)
+		exception = GetException(response)
+	}
+	diag.completed(request.frame.GetFunction(), exception)
+
+	if response == nil || listenOnly || diag.isListenOnly() {
+		diag.count(serverNoResponseCount)
+		return nil
+	}
+	if diag != nil {
+		diag.countResponse(exception)
+	}
+	return response
+}
+
+// submit passes a request to the handler goroutine.
+// It returns false if the server is shut down.
+func (s *Server) submit(request *Request) bool {
+	select {
+	case s.requestChan <- request:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// spawn runs f in a goroutine that Shutdown waits for.
+func (s *Server) spawn(f func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+}
+
+// start calls add and runs serve in a goroutine.
+// If the server is shut down, c is closed and ErrServerClosed is returned.
+func (s *Server) start(c io.Closer, add func(), serve func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		c.Close()
+		return ErrServerClosed
+	default:
+	}
+	add()
+	s.spawn(serve)
+	return nil
+}
+
+// addConn registers a client connection, so it is closed on shutdown.
+// It returns false if the server is shut down.
+func (s *Server) addConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) removeConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// stopListening marks the server as shut down and stops listening to TCP/IP and UDP ports.
+func (s *Server) stopListening() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+		s.cancel()
+	}
 	for _, listen := range s.listeners {
 		listen.Close()
 	}
+	for _, conn := range s.packetConns {
+		conn.Close()
+	}
+}
+
+// closeConnections closes the client connections and serial ports.
+func (s *Server) closeConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
 	for _, port := range s.ports {
 		port.Close()
 	}
 }
+
+// Serve blocks until ctx is done and then shuts the server down gracefully.
+// It always returns a non-nil error: ErrServerClosed after the shutdown, or
+// the error of Shutdown.
+func (s *Server) Serve(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		if err := s.Shutdown(context.Background()); err != nil {
+			return err
+		}
+	case <-s.done:
+	}
+	return ErrServerClosed
+}
+
+// Shutdown gracefully shuts down the server. It stops listening, waits for the
+// request in progress, closes all client connections and serial ports and waits
+// until all goroutines of the server have exited. If ctx is done first, the
+// connections are closed anyway and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopListening()
+
+	select {
+	case <-s.handlerDone:
+	case <-ctx.Done():
+		s.closeConnections()
+		return ctx.Err()
+	}
+	s.closeConnections()
+
+	exited := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops listening to TCP/IP and UDP ports and closes client connections and serial ports
+// immediately. Use Shutdown to wait for the request in progress and the goroutines of the server.
+func (s *Server) Close() {
+	s.stopListening()
+	s.closeConnections()
+}
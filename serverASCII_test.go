@@ -0,0 +1,60 @@
+package mbserver
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// asciiPort is a serial device connected to a Modbus ASCII master.
+type asciiPort struct {
+	*io.PipeReader
+	responses chan []byte
+}
+
+func (p *asciiPort) Write(data []byte) (int, error) {
+	p.responses <- append([]byte{}, data...)
+	return len(data), nil
+}
+
+func TestListenASCII(t *testing.T) {
+	pr, pw := io.Pipe()
+	port := &asciiPort{PipeReader: pr, responses: make(chan []byte, 4)}
+
+	s := NewServer()
+	s.SetHoldingRegisters(1, 0x6b, []uint16{0x022b})
+	if err := s.ListenASCII(port, 100*time.Millisecond); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	go func() {
+		// Noise before the frame and a frame split into several reads.
+		pw.Write([]byte("xx:0103006B"))
+		pw.Write([]byte("0001"))
+		pw.Write([]byte("90\r\n"))
+
+		// A frame exceeding the inter-character timeout is discarded.
+		pw.Write([]byte(":0103006B"))
+		time.Sleep(200 * time.Millisecond)
+		pw.Write([]byte("000190\r\n"))
+
+		// A frame with a bad LRC is discarded.
+		pw.Write([]byte(":0103006B000191\r\n"))
+
+		pw.Write([]byte(":0106006B00048A\r\n"))
+	}()
+
+	for _, expect := range []string{
+		":010302022BCD\r\n",
+		":0106006B00048A\r\n",
+	} {
+		select {
+		case got := <-port.responses:
+			if string(got) != expect {
+				t.Errorf("expected %q, got %q", expect, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q, got timeout", expect)
+		}
+	}
+}
@@ -77,17 +77,17 @@ func TestListenRTU(t *testing.T) {
 	testSequenz = testsequenz{sequenz: 0, frames: []testFrame{}}
 	rtuframe := RTUFrame{Address: 1, Function: 3}
 
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 1000, 1, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 1000, 1)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{0x01, 0x03, 0x02, 0x11, 0x22, 0x34, 0x0d}})
 
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 2000, 4, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 2000, 4)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{0x01, 0x03, 0x08, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x27, 0x11}})
 
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 3000, 2, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 3000, 2)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{0x01, 0x03, 0x04, 0x00, 0x00, 0x00, 0x00, 0xfa, 0x33}})
 
 	rtuframe = RTUFrame{Address: 3, Function: 3}
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 1000, 1, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 1000, 1)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{0x03, 0x03, 0x02, 0x12, 0x34, 0xcc, 0xf3}})
 
 	source := &dataSource{
@@ -117,14 +117,12 @@ func TestListenRTU(t *testing.T) {
 	serv := NewServer()
 	serv.NewDevice(3)
 
-	serv.Devices[1].HoldingRegisters[1000] = 0x1122
-	serv.Devices[1].HoldingRegisters[2000] = 0x3344
-	serv.Devices[1].HoldingRegisters[2001] = 0x5566
-	serv.Devices[1].HoldingRegisters[2002] = 0x7788
-	serv.Devices[1].HoldingRegisters[2003] = 0x9900
-	serv.Devices[3].HoldingRegisters[1000] = 0x1234
+	serv.SetHoldingRegisters(1, 1000, []uint16{0x1122})
+	serv.SetHoldingRegisters(1, 2000, []uint16{0x3344, 0x5566, 0x7788, 0x9900})
+	serv.SetHoldingRegisters(3, 1000, []uint16{0x1234})
 
 	_ = serv.ListenRTU(reader)
+	defer serv.Close()
 
 	time.Sleep(1000 * time.Millisecond)
 
@@ -139,20 +137,20 @@ func TestListenRTU1(t *testing.T) {
 	testSequenz = testsequenz{sequenz: 0, frames: []testFrame{}}
 	rtuframe := RTUFrame{Address: 1, Function: 3}
 
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 1000, 1, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 1000, 1)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{01, 03, 0x02, 0x11, 0x22, 0x34, 0x0d}})
 
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 2000, 4, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 2000, 4)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{0x01, 0x03, 0x08, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x27, 0x11}})
 
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 3000, 2, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 3000, 2)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{01, 03, 04, 00, 00, 00, 00, 0xfa, 0x33}})
 
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 0000, 1, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 0000, 1)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{01, 03, 02, 00, 00, 0xb8, 0x44}})
 
 	rtuframe = RTUFrame{Address: 3, Function: 3}
-	SetDataWithRegisterAndNumberAndValues(&rtuframe, 1000, 1, []uint16{})
+	SetDataWithRegisterAndNumber(&rtuframe, 1000, 1)
 	testSequenz.frames = append(testSequenz.frames, testFrame{frame: rtuframe.Bytes(), expect: []byte{0x03, 0x03, 0x02, 0x12, 0x34, 0xcc, 0xf3}})
 
 	source := &dataSource{
@@ -213,14 +211,12 @@ func TestListenRTU1(t *testing.T) {
 	serv := NewServer()
 	serv.NewDevice(3)
 
-	serv.Devices[1].HoldingRegisters[1000] = 0x1122
-	serv.Devices[1].HoldingRegisters[2000] = 0x3344
-	serv.Devices[1].HoldingRegisters[2001] = 0x5566
-	serv.Devices[1].HoldingRegisters[2002] = 0x7788
-	serv.Devices[1].HoldingRegisters[2003] = 0x9900
-	serv.Devices[3].HoldingRegisters[1000] = 0x1234
+	serv.SetHoldingRegisters(1, 1000, []uint16{0x1122})
+	serv.SetHoldingRegisters(1, 2000, []uint16{0x3344, 0x5566, 0x7788, 0x9900})
+	serv.SetHoldingRegisters(3, 1000, []uint16{0x1234})
 
-	_ = serv.ListenRTU(reader)
+	_ = serv.ListenRTUScanner(reader)
+	defer serv.Close()
 
 	time.Sleep(1 * time.Second)
 
@@ -230,3 +226,53 @@ func TestListenRTU1(t *testing.T) {
 		}
 	}
 }
+
+func TestListenRTUScanner(t *testing.T) {
+	pr, pw := io.Pipe()
+	port := &asciiPort{PipeReader: pr, responses: make(chan []byte, 4)}
+
+	s := NewServer()
+	s.SetHoldingRegisters(1, 0, []uint16{0x1234})
+	if err := s.ListenRTUScanner(port); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	readHolding := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a}
+	queryData := &RTUFrame{Address: 1, Function: 8, Data: []byte{0x00, 0x00, 1, 2, 3, 4, 5, 6}}
+	diagnostics := func(subFunction uint16) []byte {
+		return (&RTUFrame{Address: 1, Function: 8, Data: subFunctionData(subFunction, 0)}).Bytes()
+	}
+
+	// Line noise and two frames in one read.
+	stream := append([]byte{0xff, 0x00}, readHolding...)
+	stream = append(stream, queryData.Bytes()...)
+
+	for i, test := range []struct {
+		request []byte
+		expect  [][]byte
+	}{
+		{stream, [][]byte{{2, 0x12, 0x34}, queryData.Data}},
+		// The line noise is counted once as communication error.
+		{diagnostics(0x0c), [][]byte{subFunctionData(0x0c, 1)}},
+		{diagnostics(0x0b), [][]byte{subFunctionData(0x0b, 5)}},
+	} {
+		if _, err := pw.Write(test.request); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		for _, expect := range test.expect {
+			select {
+			case got := <-port.responses:
+				response, err := NewRTUFrame(got)
+				if err != nil {
+					t.Fatalf("%v: expected nil, got %v\n", i, err)
+				}
+				if !isEqual(expect, response.GetData()) {
+					t.Errorf("%v: expected %v, got %v", i, expect, response.GetData())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%v: expected %v, got timeout", i, expect)
+			}
+		}
+	}
+}
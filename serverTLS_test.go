@@ -0,0 +1,249 @@
+package mbserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a certificate signed by parent, a self signed certificate if parent is nil.
+func testCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key, got %v\n", err)
+	}
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, interface{}(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate, got %v\n", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// testClientCertificate creates a client certificate with a Modbus role extension.
+func testClientCertificate(t *testing.T, ca *tls.Certificate, serial int64, role string) tls.Certificate {
+	value, _ := asn1.MarshalWithParams(role, "utf8")
+	return testCertificate(t, &x509.Certificate{
+		SerialNumber:    big.NewInt(serial),
+		Subject:         pkix.Name{CommonName: role},
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: roleOID, Value: value}},
+	}, ca)
+}
+
+func TestListenTLS(t *testing.T) {
+	ca := testCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	serverCert := testCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+
+	// Server
+	s := NewServer()
+	// Clients with the role "viewer" must not write.
+	s.RegisterFunctionHandler(6, func(s *Server, frame Framer) ([]byte, Exception) {
+		if GetRole(frame) != "operator" {
+			return []byte{}, IllegalFunction
+		}
+		return WriteHoldingRegister(s, frame)
+	})
+	// A middleware passing a copy of the frame keeps the role.
+	s.Use(func(next FunctionHandler) FunctionHandler {
+		return func(s *Server, frame Framer) ([]byte, Exception) {
+			return next(s, frame.Copy())
+		}
+	})
+	addr := getFreePort()
+	err := s.ListenTLS(addr, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	request := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 6}
+	SetDataWithRegisterAndNumber(request, 1, 0x1234)
+
+	for i, test := range []struct {
+		role   string
+		expect Exception
+	}{
+		{"viewer", IllegalFunction},
+		{"operator", Success},
+	} {
+		clientCert := testClientCertificate(t, &ca, int64(10+i), test.role)
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			Certificates: []tls.Certificate{clientCert},
+			RootCAs:      pool,
+		})
+		if err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+
+		conn.Write(request.Bytes())
+		response, err := ReadTCPFrame(conn)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if got := GetException(response); got != test.expect {
+			t.Errorf("%v: expected %v, got %v", test.role, test.expect, got)
+		}
+	}
+
+	if got, _ := s.HoldingRegisters(1, 1, 1); !isEqual([]uint16{0x1234}, got) {
+		t.Errorf("expected %v, got %v", []uint16{0x1234}, got)
+	}
+}
+
+func TestListenTLSWithoutClientCertificate(t *testing.T) {
+	ca := testCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := testCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+
+	s := NewServer()
+	addr := getFreePort()
+	if err := s.ListenTLS(addr, &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: pool}); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+	if err == nil {
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		request := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+		SetDataWithRegisterAndNumber(request, 1, 1)
+		conn.Write(request.Bytes())
+		_, err = ReadTCPFrame(conn)
+	}
+	if err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
+
+func TestListenTLSHandshakeTimeout(t *testing.T) {
+	serverCert := testCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil)
+
+	defer func(timeout time.Duration) { tlsHandshakeTimeout = timeout }(tlsHandshakeTimeout)
+	tlsHandshakeTimeout = 50 * time.Millisecond
+
+	s := NewServer()
+	addr := getFreePort()
+	if err := s.ListenTLS(addr, &tls.Config{Certificates: []tls.Certificate{serverCert}}); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	time.Sleep(1 * time.Millisecond)
+
+	// A client that never starts the handshake is disconnected.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestRequestRole(t *testing.T) {
+	s := NewServer()
+	s.RegisterHandler(6, func(s *Server, request *Request) ([]byte, Exception) {
+		if request.Role != "operator" {
+			return []byte{}, IllegalFunction
+		}
+		return WriteHoldingRegister(s, request.Frame())
+	})
+	s.Use(func(next FunctionHandler) FunctionHandler {
+		return func(s *Server, frame Framer) ([]byte, Exception) {
+			if GetRole(frame) == "" {
+				return []byte{}, SlaveDeviceFailure
+			}
+			frame = frame.Copy()
+			frame.SetDevice(1)
+			return next(s, frame)
+		}
+	})
+
+	for _, test := range []struct {
+		role   string
+		expect Exception
+	}{
+		{"", SlaveDeviceFailure},
+		{"viewer", IllegalFunction},
+		{"operator", Success},
+	} {
+		frame := &TCPFrame{Device: 1, Function: 6}
+		SetDataWithRegisterAndNumber(frame, 1, 0x1234)
+		response := s.handle(&Request{frame: frame, Transport: TransportTLS, Role: test.role})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("%q: expected %v, got %v", test.role, test.expect, got)
+		}
+	}
+}
+
+func TestCertificateRole(t *testing.T) {
+	value, _ := asn1.MarshalWithParams("operator", "utf8")
+	cert := &x509.Certificate{Extensions: []pkix.Extension{{Id: roleOID, Value: value}}}
+	role, err := certificateRole(cert)
+	if err != nil || role != "operator" {
+		t.Errorf("expected operator, nil, got %v, %v", role, err)
+	}
+
+	cert = &x509.Certificate{Extensions: []pkix.Extension{{Id: roleOID, Value: []byte{0xff}}}}
+	if _, err = certificateRole(cert); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package mbserver
 
 import (
+	"context"
 	"fmt"
 	"github.com/goburrow/modbus"
+	"io"
+	"net"
 	"strings"
 	"testing"
 	"time"
@@ -162,8 +165,8 @@ func TestModbus(t *testing.T) {
 	}
 
 	// Input registers
-	s.Devices[100].InputRegisters[65530] = 1
-	s.Devices[100].InputRegisters[65535] = 65535
+	s.SetInputRegisters(100, 65530, []uint16{1})
+	s.SetInputRegisters(100, 65535, []uint16{65535})
 	results, err = client.ReadInputRegisters(65530, 6)
 	if err != nil {
 		t.Errorf("expected nil, got %v\n", err)
@@ -296,3 +299,223 @@ func TestBroadcastRead(t *testing.T) {
 		t.Errorf("expected %v, got %v", expecterr, goterr)
 	}
 }
+
+// exchangeTCP sends a request to the Modbus TCP server listening on addr and returns the response.
+func exchangeTCP(t *testing.T, addr string, request *TCPFrame) *TCPFrame {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	conn.Write(request.Bytes())
+	response, err := ReadTCPFrame(conn)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	return response
+}
+
+func TestPipelinedTCPRequests(t *testing.T) {
+	// Server
+	s := NewServer()
+	s.SetHoldingRegisters(1, 1, []uint16{0x1234, 0x5678})
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x01, 0x00, 0x01}
+	pipelined := []byte{
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x02, 0x00, 0x01,
+		0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x01, 0x00, 0x02,
+	}
+
+	// A request split across two segments followed by two requests in one segment.
+	conn.Write(request[:5])
+	time.Sleep(10 * time.Millisecond)
+	conn.Write(request[5:])
+	conn.Write(pipelined)
+
+	for _, expect := range [][]byte{
+		{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x12, 0x34},
+		{0x00, 0x02, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x56, 0x78},
+		{0x00, 0x03, 0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x04, 0x12, 0x34, 0x56, 0x78},
+	} {
+		response, err := ReadTCPFrame(conn)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if got := response.Bytes(); !isEqual(expect, got) {
+			t.Errorf("expected %v, got %v", expect, got)
+		}
+	}
+}
+
+func TestRTUOverTCP(t *testing.T) {
+	// Server
+	s := NewServer()
+	s.SetHoldingRegisters(1, 1, []uint16{0x1234})
+	addr := getFreePort()
+	if err := s.ListenRTUOverTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	read := &RTUFrame{Address: 1, Function: 3}
+	SetDataWithRegisterAndNumber(read, 1, 1)
+	write := &RTUFrame{Address: 1, Function: 6}
+	SetDataWithRegisterAndNumber(write, 1, 0x5678)
+
+	// Two frames in one segment.
+	conn.Write(append(read.Bytes(), write.Bytes()...))
+
+	for _, expect := range []*RTUFrame{
+		{Address: 1, Function: 3, Data: []byte{0x02, 0x12, 0x34}},
+		{Address: 1, Function: 6, Data: []byte{0x00, 0x01, 0x56, 0x78}},
+	} {
+		response := make([]byte, len(expect.Bytes()))
+		if _, err := io.ReadFull(conn, response); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if !isEqual(expect.Bytes(), response) {
+			t.Errorf("expected %v, got %v", expect.Bytes(), response)
+		}
+	}
+}
+
+func TestModbusUDP(t *testing.T) {
+	// Server
+	s := NewServer()
+	s.SetHoldingRegisters(1, 1, []uint16{0x1234})
+	addr := getFreePort()
+	if err := s.ListenUDP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Two clients, each one must receive its own response.
+	for id := uint16(1); id <= 2; id++ {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+
+		request := &TCPFrame{TransactionIdentifier: id, Device: 1, Function: 3}
+		SetDataWithRegisterAndNumber(request, 1, 1)
+		if _, err := conn.Write(request.Bytes()); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+
+		packet := make([]byte, 260)
+		n, err := conn.Read(packet)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		expect := &TCPFrame{TransactionIdentifier: id, Device: 1, Function: 3, Data: []byte{0x02, 0x12, 0x34}}
+		if got := packet[:n]; !isEqual(expect.Bytes(), got) {
+			t.Errorf("expected %v, got %v", expect.Bytes(), got)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// Server
+	s := NewServer()
+	started := make(chan struct{})
+	s.RegisterFunctionHandler(3, func(s *Server, frame Framer) ([]byte, Exception) {
+		close(started)
+		// The request in progress must be completed during the shutdown.
+		time.Sleep(100 * time.Millisecond)
+		return ReadHoldingRegisters(s, frame)
+	})
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	if err := s.ListenUDP(getFreePort()); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	if err := s.ListenRTU(&asciiPort{PipeReader: pr, responses: make(chan []byte, 1)}); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	request := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(request, 1, 1)
+	conn.Write(request.Bytes())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	// The response of the request in progress was sent before the connection was closed.
+	if _, err := ReadTCPFrame(conn); err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+	if _, err := ReadTCPFrame(conn); err != io.EOF {
+		t.Errorf("expected %v, got %v\n", io.EOF, err)
+	}
+
+	if err := s.ListenTCP(getFreePort()); err != ErrServerClosed {
+		t.Errorf("expected %v, got %v\n", ErrServerClosed, err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := NewServer()
+	if err := s.ListenTCP(getFreePort()); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- s.Serve(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != ErrServerClosed {
+			t.Errorf("expected %v, got %v\n", ErrServerClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected %v, got timeout", ErrServerClosed)
+	}
+}
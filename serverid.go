@@ -0,0 +1,64 @@
+package mbserver
+
+import "fmt"
+
+// maxServerIDSize is the maximum size of the server ID, the run indicator status
+// and the additional data, limited by the PDU size of 253 bytes.
+const maxServerIDSize = 251
+
+// ServerID is the identity of a device returned by Report Server ID (function 17).
+type ServerID struct {
+	// ID is the device specific server ID.
+	ID []byte
+	// Running is the run indicator status, true is reported as ON (0xFF).
+	Running bool
+	// Data is the additional device specific data.
+	Data []byte
+}
+
+// SetServerID sets the identity of device id returned by Report Server ID.
+// By default a device reports its id as server ID and the run indicator ON.
+func (s *Server) SetServerID(id byte, serverID ServerID) error {
+	return s.withDevice(id, func(device *Device) error {
+		if size := len(serverID.ID) + 1 + len(serverID.Data); size > maxServerIDSize {
+			return fmt.Errorf("mbserver: server ID size %v exceeds %v bytes", size, maxServerIDSize)
+		}
+		device.serverID = &ServerID{
+			ID:      append([]byte{}, serverID.ID...),
+			Running: serverID.Running,
+			Data:    append([]byte{}, serverID.Data...),
+		}
+		return nil
+	})
+}
+
+// ReportServerID function 17, returns the server ID, the run indicator status and
+// the additional data of a device. The specification defines the function for serial
+// lines, it is answered on all transports because it uses no serial line state and
+// masters behind TCP gateways send it too.
+func ReportServerID(s *Server, request *Request) ([]byte, Exception) {
+	frame := request.Frame()
+	device := frame.GetDevice()
+
+	if len(frame.GetData()) != 0 {
+		infolog.Printf("ReportServerID from Device %v >> Exception: IllegalDataValue, PDU length %v\n", device, len(frame.GetData()))
+		return []byte{}, IllegalDataValue
+	}
+
+	debuglog.Printf("ReportServerID from Device %v\n", device)
+
+	serverID := s.Devices[device].serverID
+	if serverID == nil {
+		serverID = &ServerID{ID: []byte{device}, Running: true}
+	}
+
+	data := make([]byte, 1, 2+len(serverID.ID)+len(serverID.Data))
+	data[0] = byte(len(serverID.ID) + 1 + len(serverID.Data))
+	data = append(data, serverID.ID...)
+	if serverID.Running {
+		data = append(data, 0xff)
+	} else {
+		data = append(data, 0x00)
+	}
+	return append(data, serverID.Data...), Success
+}
@@ -0,0 +1,89 @@
+package mbserver
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReportServerID(t *testing.T) {
+	s := NewServer()
+	s.NewDevice(2)
+	if err := s.SetServerID(2, ServerID{ID: []byte("ACME"), Data: []byte{1, 2}}); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	for _, test := range []struct {
+		device    byte
+		transport Transport
+		data      []byte
+		expect    Exception
+		response  []byte
+	}{
+		{1, TransportRTU, nil, Success, []byte{2, 1, 0xff}},
+		{2, TransportTCP, nil, Success, []byte{7, 'A', 'C', 'M', 'E', 0x00, 1, 2}},
+		{2, TransportTCP, []byte{0}, IllegalDataValue, nil},
+	} {
+		frame := &RTUFrame{Address: test.device, Function: 17, Data: test.data}
+		response := s.handle(&Request{frame: frame, Transport: test.transport})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("device %v: expected %v, got %v", test.device, test.expect, got)
+		}
+		if got := response.GetData(); test.expect == Success && !isEqual(test.response, got) {
+			t.Errorf("device %v: expected %v, got %v", test.device, test.response, got)
+		}
+	}
+
+	if err := s.SetServerID(3, ServerID{}); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+	if err := s.SetServerID(1, ServerID{ID: make([]byte, 200), Data: make([]byte, 51)}); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
+
+func TestReportServerIDListen(t *testing.T) {
+	s := NewServer()
+	if err := s.SetServerID(1, ServerID{ID: []byte("ACME"), Running: true}); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	expect := []byte{5, 'A', 'C', 'M', 'E', 0xff}
+
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	pr, pw := io.Pipe()
+	port := &asciiPort{PipeReader: pr, responses: make(chan []byte, 1)}
+	if err := s.ListenRTU(port); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	// Allow the server to start and to avoid a connection refused on the client
+	time.Sleep(1 * time.Millisecond)
+
+	response := exchangeTCP(t, addr, &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 17})
+	if got := GetException(response); got != Success {
+		t.Errorf("TCP: expected %v, got %v", Success, got)
+	}
+	if got := response.GetData(); !isEqual(expect, got) {
+		t.Errorf("TCP: expected %v, got %v", expect, got)
+	}
+
+	request := &RTUFrame{Address: 1, Function: 17}
+	if _, err := pw.Write(request.Bytes()); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	select {
+	case got := <-port.responses:
+		response, err := NewRTUFrame(got)
+		if err != nil {
+			t.Fatalf("RTU: expected nil, got %v\n", err)
+		}
+		if !isEqual(expect, response.GetData()) {
+			t.Errorf("RTU: expected %v, got %v", expect, response.GetData())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("RTU: expected %v, got timeout", expect)
+	}
+}
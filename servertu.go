@@ -1,43 +1,145 @@
 package mbserver
 
 import (
+	"encoding/hex"
 	"io"
+	"time"
 )
 
-// ListenRTU starts the Modbus server listening to a serial device.
-// For example:  err := s.ListenRTU(&serial.Config{Address: "/dev/ttyUSB0"})
-func (s *Server) ListenRTU(port io.ReadWriteCloser) (err error) {
-	s.ports = append(s.ports, port)
-	go s.acceptSerialRequests(port)
-	return err
+// serialFrameReader delimits the frames received from a serial device.
+type serialFrameReader interface {
+	// ReadFrame returns the next frame or io.EOF after the server was shut down.
+	ReadFrame() ([]byte, error)
 }
 
-func (s *Server) acceptSerialRequests(port io.ReadWriteCloser) {
-	for {
-		buffer := make([]byte, 512)
+// serialChunk is the data returned by one read from a serial device and the time it was received.
+type serialChunk struct {
+	data     []byte
+	received time.Time
+}
 
-		bytesRead, err := port.Read(buffer)
-		if err != nil {
-			if err != io.EOF {
-				errorlog.Printf("serial read error %v\n", err)
+// readSerial starts a goroutine reading from port. The received data is time
+// stamped as soon as it is available, so the intervals between characters are
+// measured even while a request is being processed. The returned channel is
+// closed after the server was shut down.
+func (s *Server) readSerial(port io.Reader) <-chan serialChunk {
+	chunks := make(chan serialChunk, 16)
+	s.spawn(func() {
+		defer close(chunks)
+
+		for {
+			buffer := make([]byte, 512)
+
+			bytesRead, err := port.Read(buffer)
+			if bytesRead > 0 {
+				tracelog.Printf("read serial port: %v", hex.EncodeToString(buffer[:bytesRead]))
+				select {
+				case chunks <- serialChunk{data: buffer[:bytesRead], received: time.Now()}:
+				case <-s.done:
+					return
+				}
+			}
+			if err != nil {
+				select {
+				case <-s.done:
+					return
+				default:
+				}
+				if err != io.EOF {
+					errorlog.Printf("serial read error %v\n", err)
+				}
 			}
-			continue
 		}
+	})
+	return chunks
+}
 
-		if bytesRead != 0 {
+// defaultBaudRate is the baud rate used by ListenRTU, the default baud rate of the
+// Modbus serial line specification.
+const defaultBaudRate = 19200
 
-			// Set the length of the packet to the number of read bytes.
-			packet := buffer[:bytesRead]
+// ListenRTU starts the Modbus server listening to a serial device with 19200 baud,
+// the default baud rate of the Modbus serial line specification.
+// Lines with a lower baud rate must use ListenRTUWithBaud, otherwise the normal gaps
+// between their characters exceed t1.5 and every request is discarded as incomplete.
+// For example:  err := s.ListenRTU(port)
+func (s *Server) ListenRTU(port io.ReadWriteCloser) (err error) {
+	return s.ListenRTUWithBaud(port, defaultBaudRate)
+}
 
-			frame, err := NewRTUFrame(packet)
-			if err != nil {
-				warninglog.Printf("bad serial frame error %v\n", err)
-				continue
-			}
+// ListenRTUWithBaud starts the Modbus server listening to a serial device.
+// The baud rate of the serial device is used to delimit the RTU frames by the
+// silent intervals t1.5 and t3.5, so port can be any io.ReadWriteCloser.
+// For example:  err := s.ListenRTUWithBaud(port, 9600)
+func (s *Server) ListenRTUWithBaud(port io.ReadWriteCloser, baudRate int) (err error) {
+	timing, err := newRTUTiming(baudRate)
+	if err != nil {
+		errorlog.Printf("Failed to Listen: %v\n", err)
+		return err
+	}
+	diag := newSerialDiagnostics()
+	return s.start(port,
+		func() { s.ports = append(s.ports, port) },
+		func() {
+			reader := newRTUReader(s.readSerial(port), timing)
+			reader.diag = diag
+			s.acceptSerialRequests(port, TransportRTU, diag, reader, decodeRTUFrame)
+		})
+}
+
+// ListenRTUScanner starts the Modbus server listening to a serial device without
+// silent interval detection. The RTU frames are delimited by their length predicted
+// from the function code (see ScanRTUFrames), which works with serial adapters that
+// have an unpredictable latency, e.g. USB-RS485 converters.
+// Requests of function codes without a known length are skipped as line noise.
+// This includes function codes the server doesn't implement that are added by
+// RegisterFunctionHandler, RegisterHandler or RegisterDeviceHandler, which are
+// logged as warning. Use ListenRTU or ListenRTUWithBaud for these functions.
+// For example:  err := s.ListenRTUScanner(port)
+func (s *Server) ListenRTUScanner(port io.ReadWriteCloser) (err error) {
+	s.warnUnknownLengths("ListenRTUScanner")
+	diag := newSerialDiagnostics()
+	return s.start(port,
+		func() { s.ports = append(s.ports, port) },
+		func() {
+			reader := &rtuScanner{chunks: s.readSerial(port), diag: diag}
+			s.acceptSerialRequests(port, TransportRTU, diag, reader, decodeRTUFrame)
+		})
+}
+
+func decodeRTUFrame(packet []byte) (Framer, error) {
+	return NewRTUFrame(packet)
+}
+
+// acceptSerialRequests reads the requests from a serial device. The bus counters
+// of diag count all frames on the serial line, including the frames to other devices.
+func (s *Server) acceptSerialRequests(port io.ReadWriteCloser, transport Transport, diag *serialDiagnostics, reader serialFrameReader, decode func([]byte) (Framer, error)) {
+	src := &source{
+		reply:     port,
+		ctx:       s.ctx,
+		transport: transport,
+		local:     newSerialAddr(port, transport),
+		session:   &Session{},
+		diag:      diag,
+	}
+	for {
+		packet, err := reader.ReadFrame()
+		if err != nil {
+			return
+		}
+		diag.count(busMessageCount)
+
+		frame, err := decode(packet)
+		if err != nil {
+			warninglog.Printf("bad serial frame error %v\n", err)
+			diag.lineError(busCommunicationErrorCount)
+			continue
+		}
 
-			request := &Request{port, frame}
+		request := src.request(frame)
 
-			s.requestChan <- request
+		if !s.submit(request) {
+			return
 		}
 	}
 }
@@ -1,12 +1,14 @@
 package mbserver
 
 import (
+	"bufio"
+	"context"
 	"io"
 	"net"
 	"strings"
 )
 
-func (s *Server) accept(listen net.Listener) error {
+func (s *Server) accept(listen net.Listener, serve func(conn net.Conn)) error {
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
@@ -17,32 +19,93 @@ func (s *Server) accept(listen net.Listener) error {
 			return err
 		}
 
-		go func(conn net.Conn) {
+		if !s.addConn(conn) {
+			conn.Close()
+			return nil
+		}
+
+		s.spawn(func() {
+			defer s.removeConn(conn)
 			defer conn.Close()
+			serve(conn)
+		})
+	}
+}
+
+// newConnSource returns the source of the requests received from a connection
+// and the function canceling the context of the requests.
+func (s *Server) newConnSource(conn net.Conn, transport Transport) (*source, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	return &source{
+		reply:     conn,
+		ctx:       ctx,
+		transport: transport,
+		remote:    conn.RemoteAddr(),
+		local:     conn.LocalAddr(),
+		session:   &Session{},
+	}, cancel
+}
+
+// serveTCP reads Modbus TCP frames from a connection.
+func (s *Server) serveTCP(conn net.Conn) {
+	s.serveTCPFrames(conn, TransportTCP, "")
+}
+
+// serveTCPFrames reads Modbus TCP frames from a connection,
+// role is the Modbus role of the client.
+func (s *Server) serveTCPFrames(conn net.Conn, transport Transport, role string) {
+	src, cancel := s.newConnSource(conn, transport)
+	defer cancel()
+	src.role = role
 
-			for {
-				packet := make([]byte, 512)
-				bytesRead, err := conn.Read(packet)
-				if err != nil {
-					if err != io.EOF {
-						warninglog.Printf("read error %v\n", err)
-					}
-					return
-				}
-				// Set the length of the packet to the number of read bytes.
-				packet = packet[:bytesRead]
-
-				frame, err := NewTCPFrame(packet)
-				if err != nil {
-					warninglog.Printf("bad packet error %v\n", err)
-					return
-				}
-
-				request := &Request{conn, frame}
-
-				s.requestChan <- request
+	// Requests may arrive split across several reads or several
+	// requests may arrive in one read, so the connection is decoded
+	// as a stream of MBAP frames.
+	reader := bufio.NewReader(conn)
+	for {
+		tcpFrame, err := ReadTCPFrame(reader)
+		if err != nil {
+			if err != io.EOF {
+				warninglog.Printf("bad packet error %v\n", err)
 			}
-		}(conn)
+			return
+		}
+
+		request := src.request(tcpFrame)
+
+		if !s.submit(request) {
+			return
+		}
+	}
+}
+
+// serveRTUOverTCP reads Modbus RTU frames from a connection.
+func (s *Server) serveRTUOverTCP(conn net.Conn) {
+	src, cancel := s.newConnSource(conn, TransportRTUOverTCP)
+	defer cancel()
+
+	// There are no silent intervals on a TCP connection,
+	// so the frames are delimited by their predicted length.
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(ScanRTUFrames)
+	for scanner.Scan() {
+		// The scanner reuses its buffer, the request is handled asynchronously.
+		packet := append([]byte{}, scanner.Bytes()...)
+
+		frame, err := NewRTUFrame(packet)
+		if err != nil {
+			warninglog.Printf("bad packet error %v\n", err)
+			continue
+		}
+
+		request := src.request(frame)
+
+		if !s.submit(request) {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		warninglog.Printf("read error %v\n", err)
 	}
 }
 
@@ -53,7 +116,24 @@ func (s *Server) ListenTCP(addressPort string) (err error) {
 		errorlog.Printf("Failed to Listen: %v\n", err)
 		return err
 	}
-	s.listeners = append(s.listeners, listen)
-	go s.accept(listen)
-	return err
+	return s.start(listen,
+		func() { s.listeners = append(s.listeners, listen) },
+		func() { s.accept(listen, s.serveTCP) })
+}
+
+// ListenRTUOverTCP starts the Modbus server listening on "address:port" for
+// Modbus RTU frames tunneled over TCP (address, PDU and CRC without MBAP header),
+// as used by serial to Ethernet converters. The frames are delimited by
+// ScanRTUFrames, like ListenRTUScanner it skips the requests of function codes
+// without a known length.
+func (s *Server) ListenRTUOverTCP(addressPort string) (err error) {
+	s.warnUnknownLengths("ListenRTUOverTCP")
+	listen, err := net.Listen("tcp", addressPort)
+	if err != nil {
+		errorlog.Printf("Failed to Listen: %v\n", err)
+		return err
+	}
+	return s.start(listen,
+		func() { s.listeners = append(s.listeners, listen) },
+		func() { s.accept(listen, s.serveRTUOverTCP) })
 }
@@ -0,0 +1,115 @@
+package mbserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"time"
+)
+
+// roleOID is the object identifier of the Modbus role certificate extension.
+var roleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// tlsHandshakeTimeout is the time a client has to complete the TLS handshake.
+var tlsHandshakeTimeout = 10 * time.Second
+
+// roleFrame is the frame passed to a FunctionHandler or Middleware for a request
+// with a role, so the role is available by GetRole.
+type roleFrame struct {
+	Framer
+	role string
+}
+
+// Copy the frame keeping the role.
+func (frame *roleFrame) Copy() Framer {
+	return &roleFrame{Framer: frame.Framer.Copy(), role: frame.role}
+}
+
+// withRole returns frame carrying the role of request.
+func withRole(request *Request, frame Framer) Framer {
+	if request.Role == "" {
+		return frame
+	}
+	return &roleFrame{Framer: withoutRole(frame), role: request.Role}
+}
+
+// withoutRole returns the frame wrapped by withRole.
+func withoutRole(frame Framer) Framer {
+	if f, ok := frame.(*roleFrame); ok {
+		return f.Framer
+	}
+	return frame
+}
+
+// GetRole returns the Modbus role of the client that sent the frame, for
+// FunctionHandler and Middleware. RequestHandler use Request.Role.
+// The role is taken from the role extension of the client certificate and is
+// empty if the frame wasn't received by ListenTLS or the certificate has no role.
+func GetRole(frame Framer) string {
+	if f, ok := frame.(*roleFrame); ok {
+		return f.role
+	}
+	return ""
+}
+
+// certificateRole returns the Modbus role of a certificate.
+func certificateRole(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(roleOID) {
+			continue
+		}
+		var role string
+		if _, err := asn1.Unmarshal(ext.Value, &role); err != nil {
+			return "", fmt.Errorf("mbserver: invalid role extension: %v", err)
+		}
+		return role, nil
+	}
+	return "", nil
+}
+
+// serveTLS authenticates the client and reads Modbus TCP frames from a TLS connection.
+func (s *Server) serveTLS(conn net.Conn) {
+	tlsConn := conn.(*tls.Conn)
+	// A client that doesn't complete the handshake must not hold the connection until shutdown.
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		warninglog.Printf("tls handshake error %v\n", err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	// The handshake requires a verified client certificate.
+	role, err := certificateRole(tlsConn.ConnectionState().PeerCertificates[0])
+	if err != nil {
+		warninglog.Printf("client %v: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	debuglog.Printf("client %v authenticated with role %q\n", conn.RemoteAddr(), role)
+
+	s.serveTCPFrames(conn, TransportTLS, role)
+}
+
+// ListenTLS starts the Modbus server listening on "address:port" using the
+// Modbus/TCP Security protocol (Modbus TCP over TLS, port 802 by default).
+// Clients must authenticate with a certificate verified by config.ClientCAs.
+// The Modbus role of the client certificate is available to the function
+// handlers by Request.Role or GetRole.
+func (s *Server) ListenTLS(addressPort string, config *tls.Config) (err error) {
+	config = config.Clone()
+	// Modbus/TCP Security requires mutual authentication and at least TLS 1.2.
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if config.MinVersion < tls.VersionTLS12 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	listen, err := tls.Listen("tcp", addressPort, config)
+	if err != nil {
+		errorlog.Printf("Failed to Listen: %v\n", err)
+		return err
+	}
+	return s.start(listen,
+		func() { s.listeners = append(s.listeners, listen) },
+		func() { s.accept(listen, s.serveTLS) })
+}
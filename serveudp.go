@@ -0,0 +1,64 @@
+package mbserver
+
+import (
+	"net"
+	"strings"
+)
+
+// udpReply writes the response to the source address of a datagram.
+type udpReply struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+func (r *udpReply) Write(data []byte) (int, error) {
+	return r.conn.WriteTo(data, r.addr)
+}
+
+func (s *Server) acceptDatagrams(conn net.PacketConn) error {
+	for {
+		// A datagram contains exactly one Modbus TCP frame (MBAP header and PDU).
+		packet := make([]byte, tcpHeaderSize-1+tcpMaxLength)
+		bytesRead, addr, err := conn.ReadFrom(packet)
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+			warninglog.Printf("Unable to read datagrams: %#v\n", err)
+			return err
+		}
+
+		frame, err := NewTCPFrame(packet[:bytesRead])
+		if err != nil {
+			warninglog.Printf("bad datagram from %v error %v\n", addr, err)
+			continue
+		}
+
+		src := &source{
+			reply:     &udpReply{conn, addr},
+			ctx:       s.ctx,
+			transport: TransportUDP,
+			remote:    addr,
+			local:     conn.LocalAddr(),
+			session:   &Session{},
+		}
+		request := src.request(frame)
+
+		if !s.submit(request) {
+			return nil
+		}
+	}
+}
+
+// ListenUDP starts the Modbus server listening for Modbus/UDP datagrams on "address:port".
+// The response is sent to the source address of the request.
+func (s *Server) ListenUDP(addressPort string) (err error) {
+	conn, err := net.ListenPacket("udp", addressPort)
+	if err != nil {
+		errorlog.Printf("Failed to Listen: %v\n", err)
+		return err
+	}
+	return s.start(conn,
+		func() { s.packetConns = append(s.packetConns, conn) },
+		func() { s.acceptDatagrams(conn) })
+}
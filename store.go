@@ -0,0 +1,240 @@
+package mbserver
+
+// Table identifies one of the four Modbus data tables.
+type Table uint8
+
+const (
+	// CoilTable contains the coils, single bit read-write values.
+	CoilTable Table = iota
+	// DiscreteInputTable contains the discrete inputs, single bit read-only values.
+	DiscreteInputTable
+	// HoldingRegisterTable contains the holding registers, 16-bit read-write values.
+	HoldingRegisterTable
+	// InputRegisterTable contains the input registers, 16-bit read-only values.
+	InputRegisterTable
+)
+
+func (t Table) String() string {
+	switch t {
+	case CoilTable:
+		return "Coils"
+	case DiscreteInputTable:
+		return "DiscreteInputs"
+	case HoldingRegisterTable:
+		return "HoldingRegisters"
+	case InputRegisterTable:
+		return "InputRegisters"
+	default:
+		return "unknown"
+	}
+}
+
+// Store is the memory of a Modbus device. The built-in function handlers read and
+// write the device memory through the Store, so a device can be backed by sparse maps,
+// a database or live values of the application.
+//
+// The bit methods are called for the CoilTable and DiscreteInputTable, the register
+// methods for the HoldingRegisterTable and InputRegisterTable. The range is always
+// within the address space 0-65535. An Exception other than Success is returned to
+// the master, e.g. IllegalDataAddress or SlaveDeviceFailure.
+type Store interface {
+	ReadBits(table Table, address uint16, quantity int) ([]bool, Exception)
+	WriteBits(table Table, address uint16, values []bool) Exception
+	ReadRegisters(table Table, address uint16, quantity int) ([]uint16, Exception)
+	WriteRegisters(table Table, address uint16, values []uint16) Exception
+}
+
+const (
+	// pageSize is the number of registers allocated at once by the MemoryStore.
+	pageSize = 256
+	// bitsetWords is the number of 64-bit words for the 65536 bits of a table.
+	bitsetWords = 65536 / 64
+)
+
+// registerPage is the memory for pageSize consecutive registers.
+type registerPage [pageSize]uint16
+
+// MemoryStore is the default Store. The coils and discrete inputs are stored as
+// bitsets and the registers in pages, both allocated on the first write of a non
+// zero value. Reading memory that was never written returns zeros, so a device
+// costs only a few KB until the application or a master writes to it.
+type MemoryStore struct {
+	bits      [2]*[bitsetWords]uint64
+	registers [2][65536 / pageSize]*registerPage
+}
+
+// NewMemoryStore creates a MemoryStore for 65536 coils, discrete inputs, holding registers and input registers.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// ReadBits reads coils or discrete inputs.
+func (m *MemoryStore) ReadBits(table Table, address uint16, quantity int) ([]bool, Exception) {
+	if table > DiscreteInputTable || int(address)+quantity > 65536 {
+		return nil, IllegalDataAddress
+	}
+	values := make([]bool, quantity)
+	bits := m.bits[table]
+	if bits == nil {
+		return values, Success
+	}
+	for i := range values {
+		pos := int(address) + i
+		values[i] = bits[pos/64]&(1<<uint(pos%64)) != 0
+	}
+	return values, Success
+}
+
+// WriteBits writes coils or discrete inputs.
+func (m *MemoryStore) WriteBits(table Table, address uint16, values []bool) Exception {
+	if table > DiscreteInputTable || int(address)+len(values) > 65536 {
+		return IllegalDataAddress
+	}
+	for i, value := range values {
+		pos := int(address) + i
+		if m.bits[table] == nil {
+			if !value {
+				continue
+			}
+			m.bits[table] = new([bitsetWords]uint64)
+		}
+		if value {
+			m.bits[table][pos/64] |= 1 << uint(pos%64)
+		} else {
+			m.bits[table][pos/64] &^= 1 << uint(pos%64)
+		}
+	}
+	return Success
+}
+
+// ReadRegisters reads holding or input registers.
+func (m *MemoryStore) ReadRegisters(table Table, address uint16, quantity int) ([]uint16, Exception) {
+	if table < HoldingRegisterTable || table > InputRegisterTable || int(address)+quantity > 65536 {
+		return nil, IllegalDataAddress
+	}
+	pages := &m.registers[table-HoldingRegisterTable]
+	values := make([]uint16, quantity)
+	for i := range values {
+		pos := int(address) + i
+		if page := pages[pos/pageSize]; page != nil {
+			values[i] = page[pos%pageSize]
+		}
+	}
+	return values, Success
+}
+
+// WriteRegisters writes holding or input registers.
+func (m *MemoryStore) WriteRegisters(table Table, address uint16, values []uint16) Exception {
+	if table < HoldingRegisterTable || table > InputRegisterTable || int(address)+len(values) > 65536 {
+		return IllegalDataAddress
+	}
+	pages := &m.registers[table-HoldingRegisterTable]
+	for i, value := range values {
+		pos := int(address) + i
+		page := pages[pos/pageSize]
+		if page == nil {
+			if value == 0 {
+				continue
+			}
+			page = new(registerPage)
+			pages[pos/pageSize] = page
+		}
+		page[pos%pageSize] = value
+	}
+	return Success
+}
+
+// ArrayStore is a Store with the full address space of all four tables allocated as
+// slices, the memory layout of Device before the Store interface was added. The
+// application may access the slices directly inside Server.Update, e.g. to port code
+// that indexed s.Devices[id].HoldingRegisters:
+//
+//	store := NewArrayStore()
+//	s.NewDeviceWithStore(2, store)
+//	s.Update(func(m Memory) error {
+//		store.HoldingRegisters[100] = 0x1234
+//		return nil
+//	})
+type ArrayStore struct {
+	DiscreteInputs   []byte
+	Coils            []byte
+	HoldingRegisters []uint16
+	InputRegisters   []uint16
+}
+
+// NewArrayStore allocates the memory for 65536 coils, discrete inputs, holding registers and input registers.
+func NewArrayStore() *ArrayStore {
+	return &ArrayStore{
+		DiscreteInputs:   make([]byte, 65536),
+		Coils:            make([]byte, 65536),
+		HoldingRegisters: make([]uint16, 65536),
+		InputRegisters:   make([]uint16, 65536),
+	}
+}
+
+func (a *ArrayStore) bits(table Table) []byte {
+	switch table {
+	case CoilTable:
+		return a.Coils
+	case DiscreteInputTable:
+		return a.DiscreteInputs
+	}
+	return nil
+}
+
+func (a *ArrayStore) registers(table Table) []uint16 {
+	switch table {
+	case HoldingRegisterTable:
+		return a.HoldingRegisters
+	case InputRegisterTable:
+		return a.InputRegisters
+	}
+	return nil
+}
+
+// ReadBits reads coils or discrete inputs.
+func (a *ArrayStore) ReadBits(table Table, address uint16, quantity int) ([]bool, Exception) {
+	bits := a.bits(table)
+	if int(address)+quantity > len(bits) {
+		return nil, IllegalDataAddress
+	}
+	values := make([]bool, quantity)
+	for i := range values {
+		values[i] = bits[int(address)+i] != 0
+	}
+	return values, Success
+}
+
+// WriteBits writes coils or discrete inputs.
+func (a *ArrayStore) WriteBits(table Table, address uint16, values []bool) Exception {
+	bits := a.bits(table)
+	if int(address)+len(values) > len(bits) {
+		return IllegalDataAddress
+	}
+	for i, value := range values {
+		bits[int(address)+i] = 0
+		if value {
+			bits[int(address)+i] = 1
+		}
+	}
+	return Success
+}
+
+// ReadRegisters reads holding or input registers.
+func (a *ArrayStore) ReadRegisters(table Table, address uint16, quantity int) ([]uint16, Exception) {
+	registers := a.registers(table)
+	if int(address)+quantity > len(registers) {
+		return nil, IllegalDataAddress
+	}
+	return append([]uint16{}, registers[int(address):int(address)+quantity]...), Success
+}
+
+// WriteRegisters writes holding or input registers.
+func (a *ArrayStore) WriteRegisters(table Table, address uint16, values []uint16) Exception {
+	registers := a.registers(table)
+	if int(address)+len(values) > len(registers) {
+		return IllegalDataAddress
+	}
+	copy(registers[address:], values)
+	return Success
+}
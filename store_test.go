@@ -0,0 +1,153 @@
+package mbserver
+
+import "testing"
+
+// mapStore is a sparse Store containing only the holding registers of a map.
+type mapStore struct {
+	registers map[uint16]uint16
+}
+
+func (m *mapStore) ReadBits(table Table, address uint16, quantity int) ([]bool, Exception) {
+	return nil, IllegalDataAddress
+}
+
+func (m *mapStore) WriteBits(table Table, address uint16, values []bool) Exception {
+	return IllegalDataAddress
+}
+
+func (m *mapStore) ReadRegisters(table Table, address uint16, quantity int) ([]uint16, Exception) {
+	if table != HoldingRegisterTable {
+		return nil, IllegalDataAddress
+	}
+	values := make([]uint16, quantity)
+	for i := range values {
+		value, ok := m.registers[address+uint16(i)]
+		if !ok {
+			return nil, IllegalDataAddress
+		}
+		values[i] = value
+	}
+	return values, Success
+}
+
+func (m *mapStore) WriteRegisters(table Table, address uint16, values []uint16) Exception {
+	if table != HoldingRegisterTable {
+		return IllegalDataAddress
+	}
+	for i, value := range values {
+		if _, ok := m.registers[address+uint16(i)]; !ok {
+			return IllegalDataAddress
+		}
+		m.registers[address+uint16(i)] = value
+	}
+	return Success
+}
+
+func TestNewDeviceWithStore(t *testing.T) {
+	store := &mapStore{registers: map[uint16]uint16{10: 1, 11: 2}}
+	s := NewServer()
+	if err := s.NewDeviceWithStore(2, store); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	for _, test := range []struct {
+		function byte
+		address  uint16
+		number   uint16
+		expect   Exception
+	}{
+		{3, 10, 2, Success},
+		{3, 11, 2, IllegalDataAddress},
+		{6, 11, 7, Success},
+		{6, 12, 7, IllegalDataAddress},
+		{1, 10, 1, IllegalDataAddress},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 2, Function: test.function}
+		SetDataWithRegisterAndNumber(frame, test.address, test.number)
+		response := s.handle(&Request{frame: frame})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("function %v address %v: expected %v, got %v", test.function, test.address, test.expect, got)
+		}
+	}
+
+	if got := store.registers[11]; got != 7 {
+		t.Errorf("expected %v, got %v", 7, got)
+	}
+	if err := s.NewDeviceWithStore(2, store); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	m := NewMemoryStore()
+	if exception := m.WriteBits(CoilTable, 65535, []bool{true}); exception != Success {
+		t.Errorf("expected Success, got %v", exception)
+	}
+	if values, exception := m.ReadBits(CoilTable, 65534, 2); !isEqual([]bool{false, true}, values) || exception != Success {
+		t.Errorf("expected [false true] Success, got %v %v", values, exception)
+	}
+	if exception := m.WriteRegisters(InputRegisterTable, 65535, []uint16{1, 2}); exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception)
+	}
+	if _, exception := m.ReadRegisters(HoldingRegisterTable, 65535, 2); exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception)
+	}
+}
+
+func TestMemoryStoreLazyAllocation(t *testing.T) {
+	m := NewMemoryStore()
+	m.WriteBits(DiscreteInputTable, 0, []bool{false, false})
+	m.WriteRegisters(HoldingRegisterTable, 0, []uint16{0, 0})
+	if m.bits[DiscreteInputTable] != nil || m.registers[0][0] != nil {
+		t.Errorf("expected no memory allocated for zero values")
+	}
+
+	// The values span two words of the bitset and two register pages.
+	m.WriteBits(DiscreteInputTable, 62, []bool{true, false, true})
+	m.WriteRegisters(InputRegisterTable, pageSize-1, []uint16{1, 2})
+	if values, _ := m.ReadBits(DiscreteInputTable, 61, 5); !isEqual([]bool{false, true, false, true, false}, values) {
+		t.Errorf("expected [false true false true false], got %v", values)
+	}
+	if values, _ := m.ReadBits(CoilTable, 62, 3); !isEqual([]bool{false, false, false}, values) {
+		t.Errorf("expected [false false false], got %v", values)
+	}
+	if values, _ := m.ReadRegisters(InputRegisterTable, pageSize-2, 4); !isEqual([]uint16{0, 1, 2, 0}, values) {
+		t.Errorf("expected [0 1 2 0], got %v", values)
+	}
+	if m.registers[1][2] != nil || m.registers[0][0] != nil {
+		t.Errorf("expected only the written pages to be allocated")
+	}
+
+	m.WriteBits(DiscreteInputTable, 62, []bool{false})
+	if values, _ := m.ReadBits(DiscreteInputTable, 62, 1); !isEqual([]bool{false}, values) {
+		t.Errorf("expected [false], got %v", values)
+	}
+}
+
+func TestArrayStore(t *testing.T) {
+	store := NewArrayStore()
+	s := NewServer()
+	if err := s.NewDeviceWithStore(2, store); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	s.Update(func(m Memory) error {
+		store.InputRegisters[10] = 0x1234
+		return nil
+	})
+
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 2, Function: 4}
+	SetDataWithRegisterAndNumber(frame, 10, 1)
+	if got := s.handle(&Request{frame: frame}).GetData(); !isEqual([]byte{2, 0x12, 0x34}, got) {
+		t.Errorf("expected %v, got %v", []byte{2, 0x12, 0x34}, got)
+	}
+
+	frame.Function = 5
+	SetDataWithRegisterAndNumber(frame, 65535, 0xff00)
+	s.handle(&Request{frame: frame})
+	if got := store.Coils[65535]; got != 1 {
+		t.Errorf("expected %v, got %v", 1, got)
+	}
+	if exception := store.WriteRegisters(CoilTable, 0, []uint16{1}); exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception)
+	}
+}
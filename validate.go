@@ -0,0 +1,79 @@
+package mbserver
+
+import "fmt"
+
+// Validator checks a value a master writes to address. The values of coils are 0 or 1.
+// An Exception other than Success rejects the whole write request, the memory stays unchanged.
+// The validator is called during the request handling and must not call Update or the
+// accessors of the Server, that deadlocks.
+type Validator func(address uint16, value uint16) Exception
+
+// validator is a Validator registered for a range of a table.
+type validator struct {
+	table Table
+	addressRange
+	fn Validator
+}
+
+// Limits accepts the values from min to max, other values are rejected with IllegalDataValue.
+func Limits(min, max uint16) Validator {
+	return func(address uint16, value uint16) Exception {
+		if value < min || value > max {
+			return IllegalDataValue
+		}
+		return Success
+	}
+}
+
+// OneOf accepts the enumerated values, other values are rejected with IllegalDataValue.
+func OneOf(values ...uint16) Validator {
+	return func(address uint16, value uint16) Exception {
+		for _, v := range values {
+			if value == v {
+				return Success
+			}
+		}
+		return IllegalDataValue
+	}
+}
+
+// ReadOnly rejects all writes with IllegalDataAddress.
+func ReadOnly(address uint16, value uint16) Exception {
+	return IllegalDataAddress
+}
+
+// Validate registers v for quantity addresses of table starting at address of device id.
+// All values written by a master to the range must pass all validators of the range.
+// The accessors of the application, like SetHoldingRegisters, are not validated.
+// For example:  s.Validate(1, HoldingRegisterTable, 100, 1, Limits(0, 1000))
+func (s *Server) Validate(id byte, table Table, address uint16, quantity int, v Validator) error {
+	return s.withDevice(id, func(device *Device) error {
+		if table > InputRegisterTable {
+			return fmt.Errorf("mbserver: invalid table %v", table)
+		}
+		if err := checkRange(address, quantity); err != nil {
+			return err
+		}
+		device.validators = append(device.validators, validator{table, addressRange{int(address), int(address) + quantity}, v})
+		return nil
+	})
+}
+
+// validate checks the values written to table starting at address. deviceMu must be held.
+func (d *Device) validate(table Table, address uint16, values []uint16) Exception {
+	for _, v := range d.validators {
+		if v.table != table {
+			continue
+		}
+		for i, value := range values {
+			pos := int(address) + i
+			if pos < v.start || pos >= v.end {
+				continue
+			}
+			if exception := v.fn(uint16(pos), value); exception != Success {
+				return exception
+			}
+		}
+	}
+	return Success
+}
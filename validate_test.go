@@ -0,0 +1,62 @@
+package mbserver
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	s := NewServer()
+	s.Validate(1, HoldingRegisterTable, 100, 2, Limits(10, 1000))
+	s.Validate(1, HoldingRegisterTable, 101, 1, OneOf(10, 20, 30))
+	s.Validate(1, HoldingRegisterTable, 200, 10, ReadOnly)
+	s.Validate(1, CoilTable, 5, 1, func(address uint16, value uint16) Exception {
+		if value != 0 {
+			return IllegalDataValue
+		}
+		return Success
+	})
+
+	for _, test := range []struct {
+		function byte
+		address  uint16
+		values   []uint16
+		expect   Exception
+	}{
+		{6, 100, []uint16{10}, Success},
+		{6, 100, []uint16{1001}, IllegalDataValue},
+		{6, 101, []uint16{20}, Success},
+		{6, 101, []uint16{25}, IllegalDataValue},
+		{16, 99, []uint16{1, 500, 30}, Success},
+		{16, 99, []uint16{2, 500, 31}, IllegalDataValue},
+		{16, 198, []uint16{1, 2, 3}, IllegalDataAddress},
+		{6, 210, []uint16{1}, Success},
+		{5, 5, []uint16{0xff00}, IllegalDataValue},
+		{5, 5, []uint16{0}, Success},
+		{5, 6, []uint16{0xff00}, Success},
+	} {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: test.function}
+		if test.function == 16 {
+			SetDataWithRegisterAndNumberAndValues(frame, test.address, uint16(len(test.values)), test.values)
+		} else {
+			SetDataWithRegisterAndNumber(frame, test.address, test.values[0])
+		}
+		response := s.handle(&Request{frame: frame})
+		if got := GetException(response); got != test.expect {
+			t.Errorf("function %v address %v values %v: expected %v, got %v", test.function, test.address, test.values, test.expect, got)
+		}
+	}
+
+	// The rejected writes left the memory unchanged.
+	expect := []uint16{1, 500, 30}
+	if got, _ := s.HoldingRegisters(1, 99, 3); !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if got, _ := s.HoldingRegisters(1, 198, 3); !isEqual([]uint16{0, 0, 0}, got) {
+		t.Errorf("expected %v, got %v", []uint16{0, 0, 0}, got)
+	}
+	// The application is not validated.
+	if err := s.SetHoldingRegisters(1, 200, []uint16{1}); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if err := s.Validate(2, CoilTable, 0, 1, ReadOnly); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}